@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// code of the Overloaded errors, not exported by gocql
+const errCodeOverloaded = 0x1001
+
+// writes measured before the p99 latency is compared to the target
+const adaptiveWindow = 100
+
+// minimum time between two decreases, the writes started before a decrease may fail after it
+const adaptiveCooldown = time.Second
+
+// times a row failing because the target is overloaded is written again
+const adaptiveRetries = 3
+
+// writeController adapts the rows written at the same time to the target cluster, the limit grows by
+// one after each window of writes under the p99 latency target and is halved when the p99 latency is
+// over it or the target reports to be overloaded
+type writeController struct {
+	name   string
+	target time.Duration
+	max    int
+
+	mutex     sync.Mutex
+	cond      *sync.Cond
+	limit     int
+	inFlight  int
+	latencies []time.Duration
+	decreased time.Time
+}
+
+func newWriteController(name string, target time.Duration, max int) *writeController {
+	w := &writeController{name: name, target: target, max: max, limit: 1}
+	w.cond = sync.NewCond(&w.mutex)
+	log.Println(name + ": adaptive writes, concurrency 1 up to " + strconv.Itoa(max) + ", p99 latency target " + target.String())
+
+	return w
+}
+
+// acquire blocks until a write can be started
+func (w *writeController) acquire() {
+	w.mutex.Lock()
+	for w.inFlight >= w.limit {
+		w.cond.Wait()
+	}
+	w.inFlight++
+	w.mutex.Unlock()
+}
+
+func (w *writeController) release() {
+	w.mutex.Lock()
+	w.inFlight--
+	w.cond.Broadcast()
+	w.mutex.Unlock()
+}
+
+// isOverloadError tells whether err is the target asking to write slower
+func isOverloadError(err error) bool {
+	switch e := err.(type) {
+	case *gocql.RequestErrWriteTimeout, *gocql.RequestErrUnavailable:
+		return true
+	case gocql.RequestError:
+		return e.Code() == errCodeOverloaded
+	}
+
+	return err == gocql.ErrTimeoutNoResponse
+}
+
+// observe records the latency and the error of a write and adjusts the limit
+func (w *writeController) observe(latency time.Duration, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if err != nil {
+		if isOverloadError(err) {
+			w.decrease(err.Error())
+		}
+		return
+	}
+
+	w.latencies = append(w.latencies, latency)
+	if len(w.latencies) < adaptiveWindow {
+		return
+	}
+
+	sort.Slice(w.latencies, func(i, j int) bool { return w.latencies[i] < w.latencies[j] })
+	p99 := w.latencies[len(w.latencies)*99/100]
+	w.latencies = w.latencies[:0]
+
+	if p99 > w.target {
+		w.decrease("p99 latency " + p99.String())
+	} else if w.limit < w.max {
+		w.limit++
+		log.Println(w.name + ": p99 latency " + p99.String() + ", write concurrency increased to " + strconv.Itoa(w.limit))
+		w.cond.Broadcast()
+	}
+}
+
+// decrease halves the limit at most once per cooldown, the writes of the current window started with
+// the previous limit so their latencies are dropped
+func (w *writeController) decrease(reason string) {
+	w.latencies = w.latencies[:0]
+	if w.limit == 1 || time.Since(w.decreased) < adaptiveCooldown {
+		return
+	}
+
+	w.limit /= 2
+	w.decreased = time.Now()
+	log.Println(w.name + ": " + reason + ", write concurrency decreased to " + strconv.Itoa(w.limit))
+}
@@ -4,34 +4,85 @@ import (
 	"fmt"
 	"github.com/gocql/gocql"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Cassandra struct {
 }
 
-func (c *Cassandra) getCassandraSession(host string) *gocql.Session {
-	mHost := host
-	mPort := 9042
-	if strings.Contains(host, ":") {
-		values := strings.Split(host, ":")
-		mHost = values[0]
+type TransferOptions struct {
+	Table               string
+	SkipCreateTables    bool
+	SyncSchema          bool
+	SkipSchemaCheck     bool
+	TruncateCounters    bool
+	TableConcurrency    int
+	WriteConcurrency    int
+	WriteLatencyTarget  time.Duration
+	RateLimits          RateLimits
+	TableRateLimits     RateLimits
+	ControlAddress      string
+	SkipRows            int
+	SkipInsertRowErrors bool
+	NullTombstones      bool
+	PreserveWriteTime   bool
+	Splits              int
+	StateFile           string
+	Resume              bool
+	Replication         map[string]string
+	DCMap               map[string]string
+	DurableWrites       *bool
+	TableOptions        map[string]string
+}
 
-		p, err := strconv.Atoi(values[1])
-		if err != nil {
-			panic(err)
-		}
+func (c *Cassandra) getCassandraSession(o ClusterOptions) *gocql.Session {
+	hosts, port, err := o.contactPoints()
+	if err != nil {
+		panic(err)
+	}
+
+	clusterConfig := gocql.NewCluster(hosts...)
+	clusterConfig.Port = port
+	clusterConfig.Keyspace = o.Keyspace
+	clusterConfig.Timeout = o.Timeout
+	clusterConfig.ConnectTimeout = o.ConnectTimeout
+	clusterConfig.Consistency = o.consistency
+	clusterConfig.SerialConsistency = o.serialConsistency
+	clusterConfig.PageSize = o.PageSize
+	clusterConfig.ProtoVersion = o.ProtoVersion
+	clusterConfig.Compressor = o.compressor()
+	clusterConfig.HostFilter = o.hostFilter()
+	clusterConfig.AddressTranslator = o.addressTranslator()
+
+	if o.DisableDiscovery {
+		clusterConfig.DisableInitialHostLookup = true
+		clusterConfig.Events.DisableTopologyEvents = true
+	}
 
-		mPort = p
+	if policy := o.hostSelectionPolicy(); policy != nil {
+		clusterConfig.PoolConfig.HostSelectionPolicy = policy
 	}
 
-	clusterConfig := gocql.NewCluster(mHost)
-	clusterConfig.Port = mPort
-	clusterConfig.Timeout = 60 * time.Second
-	clusterConfig.Consistency = gocql.Quorum
+	if o.Username != "" {
+		clusterConfig.Authenticator = gocql.PasswordAuthenticator{
+			Username: o.Username,
+			Password: o.Password,
+		}
+	}
+
+	if o.sslEnabled() {
+		clusterConfig.SslOpts = &gocql.SslOptions{
+			CaPath:                 o.SslCaPath,
+			CertPath:               o.SslCertPath,
+			KeyPath:                o.SslKeyPath,
+			EnableHostVerification: o.SslVerifyHost,
+		}
+	}
 
 	session, err := clusterConfig.CreateSession()
 
@@ -42,51 +93,89 @@ func (c *Cassandra) getCassandraSession(host string) *gocql.Session {
 	return session
 }
 
-func (c *Cassandra) getCreateTableQuery(keyspace string, table *gocql.TableMetadata) string {
-	var columns []string
-	var orderedColumns []string
-
-	for col, data := range table.Columns {
-		columns = append(columns, col+" "+data.Validator)
+// getReplication returns the replication options of the target keyspace, copied from the source keyspace
+// with its datacenters renamed through dcMap, unless overridden. An override with a class replaces them all.
+func (c *Cassandra) getReplication(k *gocql.KeyspaceMetadata, override map[string]string,
+	dcMap map[string]string) map[string]string {
 
-		if data.Order == true {
-			orderedColumns = append(orderedColumns, col+" "+data.ClusteringOrder)
+	replication := map[string]string{"class": k.StrategyClass}
+	if _, ok := override["class"]; !ok {
+		for option, value := range k.StrategyOptions {
+			if dc, ok := dcMap[option]; ok {
+				option = dc
+			}
+			replication[option] = fmt.Sprintf("%v", value)
 		}
+	} else {
+		replication = map[string]string{}
+	}
+
+	for option, value := range override {
+		replication[option] = value
 	}
 
-	var pkColumns []string
-	for _, pk := range table.PartitionKey {
-		pkColumns = append(pkColumns, pk.Name)
+	return replication
+}
+
+// getKeyspaceQuery builds the DDL of the target keyspace from the source one and the transfer overrides
+func (c *Cassandra) getKeyspaceQuery(k *gocql.KeyspaceMetadata, keyspace string, options TransferOptions) string {
+	durableWrites := k.DurableWrites
+	if options.DurableWrites != nil {
+		durableWrites = *options.DurableWrites
 	}
 
-	var clusteringColumns []string
-	for _, column := range table.ClusteringColumns {
-		clusteringColumns = append(clusteringColumns, column.Name)
+	return c.getCreateKeyspaceQuery(keyspace, c.getReplication(k, options.Replication, options.DCMap), durableWrites)
+}
+
+func (c *Cassandra) getCreateKeyspaceQuery(keyspace string, replication map[string]string, durableWrites bool) string {
+	var options []string
+	for option := range replication {
+		options = append(options, option)
 	}
+	sort.Strings(options)
 
-	if len(orderedColumns) > 0 && len(clusteringColumns) > 0 {
-		return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s (%s, PRIMARY KEY ((%s), %s)) WITH CLUSTERING ORDER BY (%s);",
-			keyspace, table.Name, strings.Join(columns, ","), strings.Join(pkColumns, ","),
-			strings.Join(clusteringColumns, ","), strings.Join(orderedColumns, ","))
+	var values []string
+	for _, option := range options {
+		values = append(values, c.getStringOrNumber(option)+": "+c.getStringOrNumber(replication[option]))
 	}
 
-	if len(clusteringColumns) > 0 {
-		return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s (%s, PRIMARY KEY ((%s), %s));",
-			keyspace, table.Name, strings.Join(columns, ","), strings.Join(pkColumns, ","),
-			strings.Join(clusteringColumns, ","))
+	return fmt.Sprintf("CREATE KEYSPACE IF NOT EXISTS %s WITH REPLICATION = {%s} AND DURABLE_WRITES = %t;",
+		quoteIdentifier(keyspace), strings.Join(values, ", "), durableWrites)
+}
+
+func (c *Cassandra) getTableColumnsName(table *gocql.TableMetadata) []string {
+	columns := make([]string, len(table.OrderedColumns))
+	copy(columns, table.OrderedColumns)
+
+	return columns
+}
+
+func (c *Cassandra) getSelectQuery(keyspace string, table *gocql.TableMetadata, columns []string,
+	writeTimeColumns []string) string {
+
+	selectors := quoteIdentifiers(columns)
+	for _, columnName := range quoteIdentifiers(writeTimeColumns) {
+		selectors = append(selectors, "WRITETIME("+columnName+")", "TTL("+columnName+")")
 	}
 
-	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s (%s, PRIMARY KEY ((%s)));",
-		keyspace, table.Name, strings.Join(columns, ","), strings.Join(pkColumns, ","))
+	return fmt.Sprintf("SELECT %s FROM %s", strings.Join(selectors, ","), qualifiedName(keyspace, table.Name))
 }
 
-func (c *Cassandra) getTableColumnsName(results map[string]interface{}) []string {
-	var columns []string
-	for columnName := range results {
-		columns = append(columns, columnName)
+func (c *Cassandra) getInsertQuery(keyspace string, table *gocql.TableMetadata, columns []string) string {
+	markers := make([]string, len(columns))
+	for i := range markers {
+		markers[i] = "?"
 	}
 
-	return columns
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", qualifiedName(keyspace, table.Name),
+		strings.Join(quoteIdentifiers(columns), ","), strings.Join(markers, ","))
+}
+
+// timestampLayout keeps milliseconds and an explicit zone, in a format every cassandra version parses
+const timestampLayout = "2006-01-02 15:04:05.000-0700"
+
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(timestampLayout)
 }
 
 func (c *Cassandra) getStringOrNumber(v interface{}) string {
@@ -94,227 +183,436 @@ func (c *Cassandra) getStringOrNumber(v interface{}) string {
 	case string:
 		return fmt.Sprintf("'%v'", strings.Replace(v.(string), "'", "''", -1))
 	case time.Time:
-		if v.(time.Time).IsZero() {
-			return ""
-		}
-
-		return "'" + v.(time.Time).Format("2006-01-02 03:04:05") + "'"
+		// zero times are regular values too
+		return "'" + formatTimestamp(v.(time.Time)) + "'"
 	default:
 		return fmt.Sprintf("%v", v)
 	}
 }
 
-func (c *Cassandra) getValueString(v interface{}, columnMetadata *gocql.ColumnMetadata) string {
-	if columnMetadata.Validator == "text" {
-		return c.getStringOrNumber(v)
+// getInsertDataQuery renders a row as a plain CQL statement with the columns actually written, only
+// used to report rows failing to insert
+func (c *Cassandra) getInsertDataQuery(t *tableSync, row *rawRow) string {
+	var columnsName []string
+	var values []string
+	for _, i := range c.getWrittenColumns(t, row) {
+		columnsName = append(columnsName, t.columns[i])
+		values = append(values, c.getRawValueLiteral(row.values[i]))
 	}
 
-	switch columnMetadata.Validator {
-	case "list<int>", "org.apache.cassandra.db.marshal.ListType(org.apache.cassandra.db.marshal.Int32Type)":
-		var result []string
-		for _, e := range v.([]int) {
-			result = append(result, c.getStringOrNumber(e))
-		}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", qualifiedName(t.toKeyspace, t.table.Name),
+		strings.Join(quoteIdentifiers(columnsName), ","), strings.Join(values, ","))
+}
 
-		return "[" + strings.Join(result, ",") + "]"
-	case "list<bigint>", "org.apache.cassandra.db.marshal.ListType(org.apache.cassandra.db.marshal.LongType)":
-		var result []string
-		for _, e := range v.([]int64) {
-			result = append(result, c.getStringOrNumber(e))
+// getWrittenColumns returns the indexes of the row columns to write. A partition holding static
+// values but no row is read with null clustering columns, only its static columns are written then.
+// Null cells are left out of the statement unless they are written as tombstones: unset values would
+// need protocol v4+, and the driver prepares one statement per set of columns.
+func (c *Cassandra) getWrittenColumns(t *tableSync, row *rawRow) []int {
+	staticOnly := false
+	for i, columnName := range t.columns {
+		if t.table.Columns[columnName].Kind == gocql.ColumnClusteringKey && row.values[i].isNull() {
+			staticOnly = true
+			break
 		}
+	}
 
-		return "[" + strings.Join(result, ",") + "]"
-	case "list<text>", "org.apache.cassandra.db.marshal.ListType(org.apache.cassandra.db.marshal.UTF8Type)":
-
-		var result []string
-		for _, e := range v.([]string) {
-			result = append(result, c.getStringOrNumber(e))
+	var indexes []int
+	for i, columnName := range t.columns {
+		kind := t.table.Columns[columnName].Kind
+		if staticOnly && kind != gocql.ColumnPartitionKey && kind != gocql.ColumnStatic {
+			continue
 		}
 
-		return "[" + strings.Join(result, ",") + "]"
-	case "set<int>", "org.apache.cassandra.db.marshal.SetType(org.apache.cassandra.db.marshal.Int32Type)":
-		var result []string
-		for _, e := range v.([]int) {
-			result = append(result, c.getStringOrNumber(e))
+		if row.values[i].isNull() && !t.options.NullTombstones {
+			continue
 		}
 
-		return "{" + strings.Join(result, ",") + "}"
-	case "set<bigint>", "org.apache.cassandra.db.marshal.SetType(org.apache.cassandra.db.marshal.LongType)":
-		var result []string
-		for _, e := range v.([]int64) {
-			result = append(result, c.getStringOrNumber(e))
+		indexes = append(indexes, i)
+	}
+
+	return indexes
+}
+
+func (c *Cassandra) writeRow(t *tableSync, row *rawRow) error {
+	indexes := c.getWrittenColumns(t, row)
+	if t.counter {
+		return c.writeCounterRow(t, row, indexes)
+	}
+
+	if t.options.PreserveWriteTime {
+		return c.writeRowWithWriteTime(t, row, indexes)
+	}
+
+	columns := make([]string, len(indexes))
+	values := make([]interface{}, len(indexes))
+	for j, i := range indexes {
+		columns[j] = t.columns[i]
+		values[j] = row.values[i]
+	}
+
+	// the statement is prepared once by the driver and reused for every row
+	return t.to.Query(c.getInsertQuery(t.toKeyspace, t.table, columns), values...).Exec()
+}
+
+// tableSync holds the state of the transfer of one table
+type tableSync struct {
+	from             *gocql.Session
+	to               *gocql.Session
+	fromKeyspace     string
+	toKeyspace       string
+	table            *gocql.TableMetadata
+	columns          []string
+	writeTimeColumns []string
+	options          TransferOptions
+	checkpoint       *Checkpoint
+	counter          bool
+	count            int64
+
+	// writes limits the rows written at the same time, rows are written one by one when nil
+	writes chan struct{}
+	// controller adapts the rows written at the same time instead of writes when a latency target is set
+	controller *writeController
+	throttler  *Throttler
+}
+
+func (t *tableSync) acquireWrite() {
+	if t.controller != nil {
+		t.controller.acquire()
+	} else {
+		t.writes <- struct{}{}
+	}
+}
+
+func (t *tableSync) releaseWrite() {
+	if t.controller != nil {
+		t.controller.release()
+	} else {
+		<-t.writes
+	}
+}
+
+// syncRange copies the rows of a token range, or of the whole table when r is nil. Pages are fetched
+// one by one so the progress can be saved in rc once all the rows of a page are written.
+func (c *Cassandra) syncRange(t *tableSync, partitioner string, r *tokenRange, rc *rangeCheckpoint) {
+	q := c.getSelectQuery(t.fromKeyspace, t.table, t.columns, t.writeTimeColumns)
+	var values []interface{}
+	if r != nil {
+		q += c.getTokenRangeRestriction(t.table)
+		values = r.bindValues(partitioner)
+	}
+
+	var pageState []byte
+	if rc != nil {
+		pageState = rc.PageState
+	}
+
+	var row *rawRow
+	for {
+		iter := t.from.Query(q, values...).PageState(pageState).Iter()
+		if row == nil {
+			row = newRawRow(iter.Columns())
 		}
 
-		return "{" + strings.Join(result, ",") + "}"
-	case "set<text>", "org.apache.cassandra.db.marshal.SetType(org.apache.cassandra.db.marshal.UTF8Type)":
-		var result []string
-		for _, e := range v.([]string) {
-			result = append(result, c.getStringOrNumber(e))
+		var pageWrites sync.WaitGroup
+		for row.scan(iter) {
+			t.throttler.read(t.table.Name, 1, row.size(len(row.values)))
+			count := atomic.AddInt64(&t.count, 1)
+
+			if count > int64(t.options.SkipRows) {
+				// insert data from current table row to S2.table
+				if t.writes == nil && t.controller == nil {
+					c.syncRow(t, row)
+				} else {
+					// the scanned row is reused for the next one
+					r := row.clone()
+					t.acquireWrite()
+					pageWrites.Add(1)
+					go func() {
+						defer func() {
+							t.releaseWrite()
+							pageWrites.Done()
+						}()
+						c.syncRow(t, r)
+					}()
+				}
+
+				if count%100 == 0 {
+					log.Println(t.toKeyspace + "." + t.table.Name + ": " + strconv.FormatInt(count, 10) + " rows")
+				}
+			} else if count%1000 == 0 {
+				log.Println(t.toKeyspace + "." + t.table.Name + ": " + strconv.FormatInt(count, 10) + " skipped rows")
+			}
 		}
 
-		return "{" + strings.Join(result, ",") + "}"
-	case "map<text, text>", "org.apache.cassandra.db.marshal.MapType(org.apache.cassandra.db.marshal.UTF8Type, org.apache.cassandra.db.marshal.UTF8Type)":
-		var result []string
-		for k, v := range v.(map[string]string) {
-			result = append(result, c.getStringOrNumber(k)+":"+c.getStringOrNumber(v))
+		pageState = iter.PageState()
+		if err := iter.Close(); err != nil {
+			panic(err)
 		}
 
-		return "{" + strings.Join(result, ",") + "}"
-	case "map<bigint, text>", "org.apache.cassandra.db.marshal.MapType(org.apache.cassandra.db.marshal.LongType, org.apache.cassandra.db.marshal.UTF8Type)":
-		var result []string
-		for k, v := range v.(map[int64]string) {
-			result = append(result, c.getStringOrNumber(k)+":"+c.getStringOrNumber(v))
+		// the page is done once all its rows are written
+		pageWrites.Wait()
+		if rc != nil {
+			t.checkpoint.pageDone(t.table.Name, rc, pageState, atomic.LoadInt64(&t.count))
 		}
 
-		return "{" + strings.Join(result, ",") + "}"
-	default:
-		return c.getStringOrNumber(v)
+		if len(pageState) == 0 {
+			return
+		}
 	}
 }
 
-func (c *Cassandra) getInsertDataQuery(keyspace string, table *gocql.TableMetadata, results map[string]interface{}) string {
-	columnsName := c.getTableColumnsName(results)
-
-	var params []interface{}
-	params = append(params, keyspace)
-	params = append(params, table.Name)
-
-	removedElementsCount := 0
-	columnsNameCopy := make([]string, len(columnsName))
-	copy(columnsNameCopy, columnsName)
-
-	var values []string
-	for i, columnName := range columnsNameCopy {
-		v := c.getValueString(results[columnName], table.Columns[columnName])
-		// only append column + value that are not empty
-		if v != "" && v != "''" && v != "0" && v != "<nil>" {
-			values = append(values, v)
-		} else {
-			// remove column from columnsName
-			idx := i - removedElementsCount
-			if len(columnsName) == idx {
-				columnsName = columnsName[:idx]
-			} else {
-				columnsName = append(columnsName[:idx], columnsName[idx+1:]...)
+// syncRow writes a row to the target table, a failing row stops the transfer unless insert errors are skipped
+func (c *Cassandra) syncRow(t *tableSync, row *rawRow) {
+	t.throttler.write(t.table.Name, 1, row.size(len(t.columns)))
+	start := time.Now()
+	err := c.writeRow(t, row)
+	if t.controller != nil {
+		// rows failing because the target is overloaded are written again once the concurrency is lowered,
+		// but increments which may have been applied
+		for attempt := 0; ; attempt++ {
+			t.controller.observe(time.Since(start), err)
+			if err == nil || t.counter || attempt == adaptiveRetries || !isOverloadError(err) {
+				break
 			}
+			time.Sleep(adaptiveCooldown)
+			start = time.Now()
+			err = c.writeRow(t, row)
+		}
+	}
+	if err != nil && !t.options.SkipCreateTables {
+		log.Println(err)
+		if t.counter {
+			// a timed out increment may have been applied, the row is not retried
+			log.Println("Query error: " + c.getCounterDataQuery(t, row))
+		} else {
+			log.Println("Query error: " + c.getInsertDataQuery(t, row))
+		}
 
-			removedElementsCount++
+		if !t.options.SkipInsertRowErrors {
+			panic(err)
 		}
 	}
+}
 
-	if values == nil || len(values) == 0 {
-		return ""
+// getTableSizes returns the estimated size in bytes of the keyspace tables, from the estimates of
+// the token ranges of the node answering
+func (c *Cassandra) getTableSizes(s *gocql.Session, keyspace string) map[string]int64 {
+	iter := s.Query("SELECT table_name, mean_partition_size, partitions_count FROM system.size_estimates WHERE keyspace_name = ?",
+		keyspace).Iter()
+
+	sizes := make(map[string]int64)
+	var table string
+	var meanPartitionSize, partitionsCount int64
+	for iter.Scan(&table, &meanPartitionSize, &partitionsCount) {
+		sizes[table] += meanPartitionSize * partitionsCount
 	}
 
-	params = append(params, strings.Join(columnsName, ","))
-	params = append(params, strings.Join(values, ","))
+	if err := iter.Close(); err != nil {
+		log.Println("Unable to read " + keyspace + " size estimates, tables won't be sorted by size: " + err.Error())
+		return map[string]int64{}
+	}
 
-	return fmt.Sprintf("INSERT INTO %s.%s (%s) VALUES (%s)", params...)
+	return sizes
 }
 
-func (c *Cassandra) createTable(s *gocql.Session, keyspace string, table *gocql.TableMetadata) {
-	q := c.getCreateTableQuery(keyspace, table)
-	log.Println(q)
-	err := s.Query(q).Exec()
+// getRanges returns the ranges to scan, a single nil range stands for the whole table
+func (c *Cassandra) getRanges(s *gocql.Session, options TransferOptions) []*tokenRange {
+	if options.Splits <= 1 {
+		return []*tokenRange{nil}
+	}
+
+	ranges, err := c.getTokenRanges(s, c.getPartitioner(s), options.Splits)
 	if err != nil {
 		panic(err)
 	}
+
+	return ranges
 }
 
 func (c *Cassandra) syncData(s1 *gocql.Session, s2 *gocql.Session, fromKeyspace string, toKeyspace string,
-	skipCreateTables bool, skipRows int, skipInsertRowErrors bool, table *gocql.TableMetadata) {
+	options TransferOptions, table *gocql.TableMetadata, checkpoint *Checkpoint, throttler *Throttler) {
+
+	if checkpoint != nil && checkpoint.isTableDone(table.Name) {
+		log.Println(toKeyspace + "." + table.Name + ": already synced, skipped")
+		return
+	}
 
 	log.Println("Sync table data from " + fromKeyspace + "." + table.Name + " to " + toKeyspace + "." + table.Name)
-	iter := s1.Query("SELECT * FROM " + fromKeyspace + "." + table.Name).Iter()
+	t := &tableSync{
+		from:         s1,
+		to:           s2,
+		fromKeyspace: fromKeyspace,
+		toKeyspace:   toKeyspace,
+		table:        table,
+		columns:      c.getTableColumnsName(table),
+		options:      options,
+		checkpoint:   checkpoint,
+		throttler:    throttler,
+	}
 
-	count := 0
+	if options.WriteLatencyTarget > 0 {
+		t.controller = newWriteController(toKeyspace+"."+table.Name, options.WriteLatencyTarget, options.WriteConcurrency)
+	} else if options.WriteConcurrency > 1 {
+		t.writes = make(chan struct{}, options.WriteConcurrency)
+	}
 
-	for {
-		var row = make(map[string]interface{})
-		if !iter.MapScan(row) {
-			break
+	if c.isCounterTable(table) {
+		t.counter = true
+		c.prepareCounterTable(t)
+	} else if options.PreserveWriteTime {
+		t.writeTimeColumns = c.getWriteTimeColumnsName(table)
+	}
+
+	var ranges []*tokenRange
+	var rangeCheckpoints []*rangeCheckpoint
+	if checkpoint != nil {
+		t.count = checkpoint.rows(table.Name)
+		rangeCheckpoints = checkpoint.ranges(table.Name, func() []*tokenRange {
+			return c.getRanges(s1, options)
+		})
+
+		for _, rc := range rangeCheckpoints {
+			r, err := rc.tokenRange()
+			if err != nil {
+				panic(err)
+			}
+			ranges = append(ranges, r)
 		}
+	} else {
+		ranges = c.getRanges(s1, options)
+	}
 
-		if count >= skipRows {
-			// insert data from current table row to S2.table
-			q := c.getInsertDataQuery(toKeyspace, table, row)
+	partitioner := ""
+	if len(ranges) > 0 && ranges[0] != nil {
+		partitioner = c.getPartitioner(s1)
+		log.Println(fromKeyspace + "." + table.Name + ": scanning " + strconv.Itoa(len(ranges)) + " token ranges")
+	}
 
-			if q != "" {
-				count++
-				err := s2.Query(q).Exec()
-				if err != nil && !skipCreateTables {
-					log.Println(err)
-					log.Println("Query error: " + q)
+	queue := make(chan int, len(ranges))
+	for i := range ranges {
+		queue <- i
+	}
+	close(queue)
 
-					if !skipInsertRowErrors {
-						panic(err)
-					}
-				}
+	workers := options.Splits
+	if workers < 1 {
+		workers = 1
+	}
 
-				if count%100 == 0 {
-					log.Println(toKeyspace + "." + table.Name + ": " + strconv.Itoa(count) + " rows")
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				var rc *rangeCheckpoint
+				if checkpoint != nil {
+					rc = rangeCheckpoints[i]
 				}
+				c.syncRange(t, partitioner, ranges[i], rc)
 			}
-		} else {
-			count++
+		}()
+	}
+	wg.Wait()
 
-			if count%1000 == 0 {
-				log.Println(toKeyspace + "." + table.Name + ": " + strconv.Itoa(count) + " skipped rows")
-			}
-		}
+	if checkpoint != nil {
+		checkpoint.tableDone(table.Name, t.count)
 	}
 
-	log.Println(toKeyspace + "." + table.Name + ": " + strconv.Itoa(count) + " rows")
+	log.Println(toKeyspace + "." + table.Name + ": " + strconv.FormatInt(t.count, 10) + " rows")
 }
 
-func (c *Cassandra) TransferCassandraData(fromHost string, toHost string, fromKeyspace string, toKeyspace string,
-	tableToSync string, skipCreateTables bool, skipRows int, skipInsertRowErrors bool) {
+func (c *Cassandra) TransferCassandraData(from ClusterOptions, to ClusterOptions, fromKeyspace string, toKeyspace string,
+	options TransferOptions) {
 
-	s1 := c.getCassandraSession(fromHost)
-	s2 := c.getCassandraSession(toHost)
-	// create remote Keyspace
+	s1 := c.getCassandraSession(from)
+	s2 := c.getCassandraSession(to)
 	k, err := s1.KeyspaceMetadata(fromKeyspace)
 	if err != nil {
 		panic(err)
 	}
 
-	err = s2.Query("CREATE KEYSPACE IF NOT EXISTS " + toKeyspace +
-		" WITH REPLICATION = { 'class' : 'NetworkTopologyStrategy', '4tech-fr': 3 };").Exec()
+	// create remote Keyspace and Tables, the target is left untouched when skipping them so the schema
+	// check reports a missing keyspace rather than it being created empty
+	if !options.SkipCreateTables {
+		c.executeQueries(s2, []string{c.getKeyspaceQuery(k, toKeyspace, options)})
+		c.executeQueries(s2, c.getUserDefinedQueries(s1, fromKeyspace, toKeyspace))
+		if options.SyncSchema {
+			c.executeQueries(s2, c.getSyncTablesQueries(s1, s2, k, toKeyspace, options))
+		} else {
+			c.executeQueries(s2, c.getCreateTablesQueries(s1, k, toKeyspace, options, c.getKnownOptions(s2, "tables")))
+		}
+	}
 
-	if err != nil {
-		panic(err)
+	if !options.SkipSchemaCheck {
+		c.checkSchema(s1, to, fromKeyspace, toKeyspace, options.Table)
 	}
 
-	// create remote Tables
-	if !skipCreateTables {
-		for _, table := range k.Tables {
-			if tableToSync != "" && table.Name == tableToSync {
-				c.createTable(s2, toKeyspace, table)
-				break
-			} else if tableToSync == "" {
-				c.createTable(s2, toKeyspace, table)
+	var checkpoint *Checkpoint
+	if options.StateFile != "" {
+		if options.Resume {
+			checkpoint, err = LoadCheckpoint(options.StateFile, fromKeyspace, toKeyspace)
+			if err != nil {
+				panic(err)
 			}
+		} else {
+			checkpoint = NewCheckpoint(options.StateFile, fromKeyspace, toKeyspace)
 		}
+
+		stop := make(chan struct{})
+		go checkpoint.SaveEvery(10*time.Second, stop)
+		defer close(stop)
 	}
 
 	log.Println("Tables has been created")
-	log.Println("Let's sync " + strconv.Itoa(len(k.Tables)) + " tables data")
+	throttler := NewThrottler(options.RateLimits, options.TableRateLimits)
+	throttler.Control(options.ControlAddress)
+	throttler.logLimits()
+
+	// largest tables first, so the smaller ones fill the workers left at the end
+	names := c.getTableNames(k, options.Table)
+	sizes := c.getTableSizes(s1, fromKeyspace)
+	sort.SliceStable(names, func(i, j int) bool {
+		return sizes[names[i]] > sizes[names[j]]
+	})
+
+	log.Println("Let's sync " + strconv.Itoa(len(names)) + " tables data")
+
+	queue := make(chan string, len(names))
+	for _, name := range names {
+		queue <- name
+	}
+	close(queue)
+
+	workers := options.TableConcurrency
+	if workers < 1 {
+		workers = 1
+	}
 
 	var wg sync.WaitGroup
-	wg.Add(len(k.Tables))
+	wg.Add(workers)
 
 	// inject data from S1 to S2
-	for _, t := range k.Tables {
-		go func(table *gocql.TableMetadata) {
+	for i := 0; i < workers; i++ {
+		go func() {
 			defer wg.Done()
-			if tableToSync != "" && table.Name == tableToSync {
-				c.syncData(s1, s2, fromKeyspace, toKeyspace, skipCreateTables, skipRows, skipInsertRowErrors, table)
-			} else if tableToSync == "" {
-				c.syncData(s1, s2, fromKeyspace, toKeyspace, skipCreateTables, skipRows, skipInsertRowErrors, table)
+			for name := range queue {
+				c.syncData(s1, s2, fromKeyspace, toKeyspace, options, k.Tables[name], checkpoint, throttler)
 			}
-		}(t)
+		}()
 	}
 
 	wg.Wait()
+	if checkpoint != nil {
+		checkpoint.Save()
+	}
+
+	// indexes and views are built from the loaded data rather than updated on every insert
+	if !options.SkipCreateTables {
+		c.executeQueries(s2, c.getIndexesAndViewsQueries(s1, fromKeyspace, toKeyspace, options.Table,
+			c.getKnownOptions(s2, "views")))
+	}
+
 	log.Println("End of sync")
 }
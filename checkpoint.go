@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// rangeCheckpoint is the progress of a token range scan, the whole table when Start and End are empty
+type rangeCheckpoint struct {
+	Start     string `json:"start,omitempty"`
+	End       string `json:"end,omitempty"`
+	Done      bool   `json:"done"`
+	PageState []byte `json:"page_state,omitempty"`
+}
+
+func (r *rangeCheckpoint) tokenRange() (*tokenRange, error) {
+	if r.Start == "" && r.End == "" {
+		return nil, nil
+	}
+
+	start, ok := new(big.Int).SetString(r.Start, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid range start token %s", r.Start)
+	}
+
+	end, ok := new(big.Int).SetString(r.End, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid range end token %s", r.End)
+	}
+
+	return &tokenRange{start: start, end: end}, nil
+}
+
+type tableCheckpoint struct {
+	Done   bool               `json:"done"`
+	Rows   int64              `json:"rows"`
+	Ranges []*rangeCheckpoint `json:"ranges"`
+}
+
+// Checkpoint is the progress of a transfer, periodically persisted to a local state file so an
+// interrupted run can be resumed
+type Checkpoint struct {
+	FromKeyspace string                      `json:"from_keyspace"`
+	ToKeyspace   string                      `json:"to_keyspace"`
+	Tables       map[string]*tableCheckpoint `json:"tables"`
+
+	path  string
+	mutex sync.Mutex
+	dirty bool
+}
+
+func NewCheckpoint(path string, fromKeyspace string, toKeyspace string) *Checkpoint {
+	return &Checkpoint{
+		FromKeyspace: fromKeyspace,
+		ToKeyspace:   toKeyspace,
+		Tables:       make(map[string]*tableCheckpoint),
+		path:         path,
+	}
+}
+
+// LoadCheckpoint reads the state file of a previous run, a new checkpoint is returned if there is none
+func LoadCheckpoint(path string, fromKeyspace string, toKeyspace string) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Println("No state file " + path + ", starting from scratch")
+		return NewCheckpoint(path, fromKeyspace, toKeyspace), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	cp := NewCheckpoint(path, fromKeyspace, toKeyspace)
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %s", path, err.Error())
+	}
+
+	if cp.FromKeyspace != fromKeyspace || cp.ToKeyspace != toKeyspace {
+		return nil, fmt.Errorf("state file %s is for a transfer from %s to %s", path, cp.FromKeyspace, cp.ToKeyspace)
+	}
+
+	if cp.Tables == nil {
+		cp.Tables = make(map[string]*tableCheckpoint)
+	}
+
+	return cp, nil
+}
+
+// table returns the progress of a table, created empty the first time
+func (cp *Checkpoint) table(name string) *tableCheckpoint {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	t, ok := cp.Tables[name]
+	if !ok {
+		t = &tableCheckpoint{}
+		cp.Tables[name] = t
+	}
+
+	return t
+}
+
+func (cp *Checkpoint) isTableDone(name string) bool {
+	t := cp.table(name)
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	return t.Done
+}
+
+// ranges returns the ranges of a table still to be read, they are initialized by init the first time
+func (cp *Checkpoint) ranges(name string, init func() []*tokenRange) []*rangeCheckpoint {
+	t := cp.table(name)
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	if t.Ranges == nil {
+		for _, r := range init() {
+			if r == nil {
+				t.Ranges = append(t.Ranges, &rangeCheckpoint{})
+				continue
+			}
+			t.Ranges = append(t.Ranges, &rangeCheckpoint{Start: r.start.String(), End: r.end.String()})
+		}
+		cp.dirty = true
+	}
+
+	var ranges []*rangeCheckpoint
+	for _, r := range t.Ranges {
+		if !r.Done {
+			ranges = append(ranges, r)
+		}
+	}
+
+	return ranges
+}
+
+// isTableStarted tells if some rows of a table may have been written by a previous run
+func (cp *Checkpoint) isTableStarted(name string) bool {
+	t := cp.table(name)
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	return !t.Done && (t.Rows > 0 || t.Ranges != nil)
+}
+
+// resetTable forgets the progress of a table, to copy it again from scratch
+func (cp *Checkpoint) resetTable(name string) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	cp.Tables[name] = &tableCheckpoint{}
+	cp.dirty = true
+}
+
+func (cp *Checkpoint) rows(name string) int64 {
+	t := cp.table(name)
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	return t.Rows
+}
+
+// pageDone records that every row up to pageState has been written, an empty pageState ends the range
+func (cp *Checkpoint) pageDone(name string, r *rangeCheckpoint, pageState []byte, rows int64) {
+	t := cp.table(name)
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	r.PageState = pageState
+	r.Done = len(pageState) == 0
+	t.Rows = rows
+	cp.dirty = true
+}
+
+func (cp *Checkpoint) tableDone(name string, rows int64) {
+	t := cp.table(name)
+
+	cp.mutex.Lock()
+	t.Done = true
+	t.Rows = rows
+	t.Ranges = nil
+	cp.dirty = true
+	cp.mutex.Unlock()
+
+	cp.Save()
+}
+
+// Save writes the state file if there is any progress since the last save
+func (cp *Checkpoint) Save() {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	if !cp.dirty {
+		return
+	}
+
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+
+	// write then rename so a crash never leaves a truncated state file
+	tmp := cp.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		panic(err)
+	}
+
+	if err := os.Rename(tmp, cp.path); err != nil {
+		panic(err)
+	}
+
+	cp.dirty = false
+}
+
+// SaveEvery persists the checkpoint periodically until stop is closed
+func (cp *Checkpoint) SaveEvery(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cp.Save()
+		case <-stop:
+			cp.Save()
+			return
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/spf13/pflag"
+)
+
+// ClusterOptions holds the settings used to connect to a cassandra cluster
+type ClusterOptions struct {
+	// Host is a comma separated list of contact points
+	Host          string
+	Username      string
+	Password      string
+	PasswordFile  string
+	Ssl           bool
+	SslCaPath     string
+	SslCertPath   string
+	SslKeyPath    string
+	SslVerifyHost bool
+
+	// Consistency is used to read from the source cluster and to write to the target one
+	Consistency       string
+	SerialConsistency string
+	ConnectTimeout    time.Duration
+	Timeout           time.Duration
+	PageSize          int
+	ProtoVersion      int
+	Compression       string
+
+	// LocalDC enables datacenter and token aware routing
+	LocalDC    string
+	AllowHosts []string
+	DenyHosts  []string
+
+	// AddressMap translates the addresses of discovered nodes, as private=public entries
+	AddressMap       []string
+	AddressMapFile   string
+	DisableDiscovery bool
+
+	// Keyspace is the session keyspace, used by statements with unqualified table names
+	Keyspace string
+
+	consistency       gocql.Consistency
+	serialConsistency gocql.SerialConsistency
+	addresses         map[string]string
+}
+
+func NewClusterOptions() ClusterOptions {
+	return ClusterOptions{
+		SslVerifyHost:     true,
+		Consistency:       "QUORUM",
+		SerialConsistency: "SERIAL",
+		ConnectTimeout:    600 * time.Millisecond,
+		Timeout:           60 * time.Second,
+		PageSize:          5000,
+		Compression:       "none",
+	}
+}
+
+// addClusterFlags registers the connection flags of one side ("from" or "to") of a command.
+// The host flag is left to the command as its shorthand differs.
+func addClusterFlags(flags *pflag.FlagSet, side string, o *ClusterOptions) {
+	env := envPrefix(side)
+
+	flags.StringVar(&o.Username, side+"-username", o.Username, "username for "+side+" cluster, or env "+env+"USERNAME")
+	flags.StringVar(&o.Password, side+"-password", o.Password, "password for "+side+" cluster, or env "+env+"PASSWORD")
+	flags.StringVar(&o.PasswordFile, side+"-password-file", o.PasswordFile, "file containing the password for "+side+" cluster")
+	flags.BoolVar(&o.Ssl, side+"-ssl", o.Ssl, "connect to "+side+" cluster with TLS")
+	flags.StringVar(&o.SslCaPath, side+"-ssl-ca", o.SslCaPath, "CA bundle to verify "+side+" cluster certificates")
+	flags.StringVar(&o.SslCertPath, side+"-ssl-cert", o.SslCertPath, "client certificate for "+side+" cluster")
+	flags.StringVar(&o.SslKeyPath, side+"-ssl-key", o.SslKeyPath, "client certificate key for "+side+" cluster")
+	flags.BoolVar(&o.SslVerifyHost, side+"-ssl-verify-host", o.SslVerifyHost, "verify "+side+" cluster certificates and hostnames")
+	flags.StringVar(&o.Consistency, side+"-consistency", o.Consistency, "consistency level on "+side+" cluster")
+	flags.StringVar(&o.SerialConsistency, side+"-serial-consistency", o.SerialConsistency, "serial consistency level on "+side+" cluster (SERIAL or LOCAL_SERIAL)")
+	flags.DurationVar(&o.ConnectTimeout, side+"-connect-timeout", o.ConnectTimeout, "connection timeout on "+side+" cluster")
+	flags.DurationVar(&o.Timeout, side+"-timeout", o.Timeout, "request timeout on "+side+" cluster")
+	flags.IntVar(&o.PageSize, side+"-page-size", o.PageSize, "rows fetched per page on "+side+" cluster")
+	flags.IntVar(&o.ProtoVersion, side+"-protocol-version", o.ProtoVersion, "native protocol version for "+side+" cluster, discovered when 0")
+	flags.StringVar(&o.Compression, side+"-compression", o.Compression, "connection compression for "+side+" cluster (none or snappy)")
+	flags.StringVar(&o.LocalDC, side+"-local-dc", o.LocalDC, "local datacenter of "+side+" cluster, enables DC and token aware routing")
+	flags.StringSliceVar(&o.AllowHosts, side+"-allow-hosts", o.AllowHosts, "only connect to these "+side+" cluster node addresses")
+	flags.StringSliceVar(&o.DenyHosts, side+"-deny-hosts", o.DenyHosts, "never connect to these "+side+" cluster node addresses")
+	flags.StringSliceVar(&o.AddressMap, side+"-address-map", o.AddressMap, "translate "+side+" cluster node addresses, ex: 10.0.0.1=203.0.113.1:9042")
+	flags.StringVar(&o.AddressMapFile, side+"-address-map-file", o.AddressMapFile, "file of "+side+" cluster address translations, one private=public per line")
+	flags.BoolVar(&o.DisableDiscovery, side+"-disable-discovery", o.DisableDiscovery, "only connect to the given "+side+" contact points")
+}
+
+func envPrefix(side string) string {
+	return "CASSANDRA_MIGRATOR_" + strings.ToUpper(side) + "_"
+}
+
+// resolve fills the credentials left empty on the command line from the environment and files,
+// and checks the other settings
+func (o *ClusterOptions) resolve(side string) error {
+	env := envPrefix(side)
+
+	if o.Username == "" {
+		o.Username = os.Getenv(env + "USERNAME")
+	}
+
+	if o.Password == "" && o.PasswordFile != "" {
+		data, err := ioutil.ReadFile(o.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("unable to read %s password file: %s", side, err.Error())
+		}
+		o.Password = strings.TrimRight(string(data), "\r\n")
+	}
+
+	if o.Password == "" {
+		o.Password = os.Getenv(env + "PASSWORD")
+	}
+
+	if (o.SslCertPath == "") != (o.SslKeyPath == "") {
+		return fmt.Errorf("%s client certificate and key must be given together", side)
+	}
+
+	consistency, err := gocql.ParseConsistencyWrapper(o.Consistency)
+	if err != nil {
+		return fmt.Errorf("invalid %s consistency: %s", side, err.Error())
+	}
+	o.consistency = consistency
+
+	if err := o.serialConsistency.UnmarshalText([]byte(strings.ToUpper(o.SerialConsistency))); err != nil {
+		return fmt.Errorf("invalid %s serial consistency: %s", side, err.Error())
+	}
+
+	// without a page size a whole token range is fetched at once and never checkpointed
+	if o.PageSize < 1 {
+		return fmt.Errorf("%s page size must be at least 1", side)
+	}
+
+	if o.Compression != "none" && o.Compression != "snappy" {
+		return fmt.Errorf("invalid %s compression %s", side, o.Compression)
+	}
+
+	if _, _, err := o.contactPoints(); err != nil {
+		return fmt.Errorf("invalid %s host: %s", side, err.Error())
+	}
+
+	addresses, err := o.loadAddressMap()
+	if err != nil {
+		return fmt.Errorf("invalid %s address map: %s", side, err.Error())
+	}
+	o.addresses = addresses
+
+	return nil
+}
+
+func (o *ClusterOptions) sslEnabled() bool {
+	return o.Ssl || o.SslCaPath != "" || o.SslCertPath != ""
+}
+
+func (o *ClusterOptions) compressor() gocql.Compressor {
+	if o.Compression == "snappy" {
+		return gocql.SnappyCompressor{}
+	}
+
+	return nil
+}
+
+// contactPoints parses the host list, ports default to 9042 and IPv6 addresses with a port are
+// written in brackets, ex: cassandra1,10.0.0.2:9142,[::1]:9042. The port of the first contact
+// point is returned as the one to use for discovered nodes.
+func (o *ClusterOptions) contactPoints() ([]string, int, error) {
+	var hosts []string
+	port := 0
+	for _, h := range strings.Split(o.Host, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+
+		host, p, err := net.SplitHostPort(h)
+		if err != nil {
+			// no port, or a bare IPv6 address
+			host = strings.Trim(h, "[]")
+			p = "9042"
+		}
+
+		hostPort, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid port in %s", h)
+		}
+
+		if port == 0 {
+			port = hostPort
+		}
+
+		hosts = append(hosts, net.JoinHostPort(host, p))
+	}
+
+	if len(hosts) == 0 {
+		return nil, 0, fmt.Errorf("no contact point")
+	}
+
+	return hosts, port, nil
+}
+
+func normalizeAddresses(addresses []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, a := range addresses {
+		if ip := net.ParseIP(strings.Trim(a, "[]")); ip != nil {
+			a = ip.String()
+		}
+		set[a] = true
+	}
+
+	return set
+}
+
+// hostFilter keeps migration traffic away from the denied nodes, and on the allowed ones if any
+func (o *ClusterOptions) hostFilter() gocql.HostFilter {
+	if len(o.AllowHosts) == 0 && len(o.DenyHosts) == 0 {
+		return nil
+	}
+
+	allow := normalizeAddresses(o.AllowHosts)
+	deny := normalizeAddresses(o.DenyHosts)
+
+	return gocql.HostFilterFunc(func(host *gocql.HostInfo) bool {
+		addresses := []string{host.ConnectAddress().String(), host.Peer().String()}
+		allowed := len(allow) == 0
+		for _, a := range addresses {
+			if deny[a] {
+				return false
+			}
+			allowed = allowed || allow[a]
+		}
+
+		return allowed
+	})
+}
+
+func (o *ClusterOptions) hostSelectionPolicy() gocql.HostSelectionPolicy {
+	if o.LocalDC == "" {
+		return nil
+	}
+
+	return gocql.TokenAwareHostPolicy(gocql.DCAwareRoundRobinPolicy(o.LocalDC))
+}
+
+// normalizeAddress returns ip or ip:port in a canonical form
+func normalizeAddress(address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = strings.Trim(address, "[]"), ""
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", fmt.Errorf("%s is not an IP address", address)
+	}
+
+	if port == "" {
+		return ip.String(), nil
+	}
+
+	if _, err := strconv.Atoi(port); err != nil {
+		return "", fmt.Errorf("invalid port in %s", address)
+	}
+
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+func (o *ClusterOptions) loadAddressMap() (map[string]string, error) {
+	entries := append([]string{}, o.AddressMap...)
+
+	if o.AddressMapFile != "" {
+		f, err := os.Open(o.AddressMapFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				entries = append(entries, line)
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	addresses := make(map[string]string)
+	for _, entry := range entries {
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid translation %s, private=public expected", entry)
+		}
+
+		from, err := normalizeAddress(strings.TrimSpace(kv[0]))
+		if err != nil {
+			return nil, err
+		}
+
+		to, err := normalizeAddress(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, err
+		}
+
+		addresses[from] = to
+	}
+
+	return addresses, nil
+}
+
+// addressTranslator translates discovered node addresses, an ip:port translation takes
+// precedence over an ip one. A translation without port keeps the discovered port.
+func (o *ClusterOptions) addressTranslator() gocql.AddressTranslator {
+	if len(o.addresses) == 0 {
+		return nil
+	}
+
+	return gocql.AddressTranslatorFunc(func(addr net.IP, port int) (net.IP, int) {
+		to, ok := o.addresses[net.JoinHostPort(addr.String(), strconv.Itoa(port))]
+		if !ok {
+			to, ok = o.addresses[addr.String()]
+		}
+
+		if !ok {
+			return addr, port
+		}
+
+		host, p, err := net.SplitHostPort(to)
+		if err != nil {
+			return net.ParseIP(to), port
+		}
+
+		newPort, _ := strconv.Atoi(p)
+		return net.ParseIP(host), newPort
+	})
+}
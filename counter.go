@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gocql/gocql"
+)
+
+// Counter columns can't be inserted, their values are added to the target ones with updates.
+// Increments are not idempotent: a counter row is never retried, and a row written twice, as when
+// a run is started again, is counted twice.
+
+func (c *Cassandra) isCounterTable(table *gocql.TableMetadata) bool {
+	for _, column := range table.Columns {
+		if column.Type != nil && column.Type.Type() == gocql.TypeCounter {
+			return true
+		}
+	}
+
+	return false
+}
+
+// prepareCounterTable refuses to resume the copy of a counter table halfway, the rows of its last
+// pages would be counted twice, unless the target table is truncated to copy it again from scratch
+func (c *Cassandra) prepareCounterTable(t *tableSync) {
+	name := t.toKeyspace + "." + t.table.Name
+	log.Println(name + ": counter table, increments are not idempotent and won't be retried")
+
+	if t.checkpoint != nil && t.checkpoint.isTableStarted(t.table.Name) {
+		if !t.options.TruncateCounters {
+			panic(fmt.Errorf("%s is a counter table partially copied by a previous run, resuming would count "+
+				"some rows twice, use --truncate-counters to copy it again", name))
+		}
+		t.checkpoint.resetTable(t.table.Name)
+	}
+
+	if t.options.TruncateCounters {
+		q := "TRUNCATE " + qualifiedName(t.toKeyspace, t.table.Name)
+		log.Println(q)
+		if err := t.to.Query(q).Exec(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (c *Cassandra) getCounterUpdateQuery(keyspace string, table *gocql.TableMetadata, counterColumns []string,
+	keyColumns []string) string {
+
+	var set []string
+	for _, columnName := range quoteIdentifiers(counterColumns) {
+		set = append(set, columnName+"="+columnName+"+?")
+	}
+
+	var where []string
+	for _, columnName := range quoteIdentifiers(keyColumns) {
+		where = append(where, columnName+"=?")
+	}
+
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s", qualifiedName(keyspace, table.Name),
+		strings.Join(set, ","), strings.Join(where, " AND "))
+}
+
+// getCounterColumns splits the written columns of a row in its key columns and its non null counters
+func (c *Cassandra) getCounterColumns(t *tableSync, row *rawRow, indexes []int) ([]int, []int) {
+	var keys []int
+	var counters []int
+	for _, i := range indexes {
+		kind := t.table.Columns[t.columns[i]].Kind
+		if kind == gocql.ColumnPartitionKey || kind == gocql.ColumnClusteringKey {
+			keys = append(keys, i)
+		} else if !row.values[i].isNull() {
+			counters = append(counters, i)
+		}
+	}
+
+	return keys, counters
+}
+
+func (c *Cassandra) writeCounterRow(t *tableSync, row *rawRow, indexes []int) error {
+	keys, counters := c.getCounterColumns(t, row, indexes)
+	if len(counters) == 0 {
+		return nil
+	}
+
+	var counterColumns []string
+	var keyColumns []string
+	var values []interface{}
+	for _, i := range counters {
+		counterColumns = append(counterColumns, t.columns[i])
+		values = append(values, row.values[i])
+	}
+	for _, i := range keys {
+		keyColumns = append(keyColumns, t.columns[i])
+		values = append(values, row.values[i])
+	}
+
+	return t.to.Query(c.getCounterUpdateQuery(t.toKeyspace, t.table, counterColumns, keyColumns), values...).
+		Idempotent(false).RetryPolicy(nil).Exec()
+}
+
+// getCounterDataQuery renders a counter row as a plain CQL statement, only used to report rows failing to be written
+func (c *Cassandra) getCounterDataQuery(t *tableSync, row *rawRow) string {
+	keys, counters := c.getCounterColumns(t, row, c.getWrittenColumns(t, row))
+
+	var set []string
+	for _, i := range counters {
+		columnName := quoteIdentifier(t.columns[i])
+		set = append(set, columnName+"="+columnName+"+"+c.getRawValueLiteral(row.values[i]))
+	}
+
+	var where []string
+	for _, i := range keys {
+		where = append(where, quoteIdentifier(t.columns[i])+"="+c.getRawValueLiteral(row.values[i]))
+	}
+
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s", qualifiedName(t.toKeyspace, t.table.Name),
+		strings.Join(set, ","), strings.Join(where, " AND "))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// splitStatements splits a CQL script in statements on the semicolons which are not in a string,
+// a quoted identifier, a $$ function body or a comment. Comments are left out of the statements.
+func splitStatements(script string) ([]string, error) {
+	var statements []string
+	var statement strings.Builder
+
+	end := func() {
+		if q := strings.TrimSpace(statement.String()); q != "" {
+			statements = append(statements, q)
+		}
+		statement.Reset()
+	}
+
+	for i := 0; i < len(script); {
+		rest := script[i:]
+		switch {
+		case strings.HasPrefix(rest, "--") || strings.HasPrefix(rest, "//"):
+			n := strings.IndexByte(rest, '\n')
+			if n < 0 {
+				n = len(rest)
+			}
+			statement.WriteByte(' ')
+			i += n
+		case strings.HasPrefix(rest, "/*"):
+			n := strings.Index(rest[2:], "*/")
+			if n < 0 {
+				return nil, fmt.Errorf("unterminated comment at offset %d", i)
+			}
+			statement.WriteByte(' ')
+			i += n + 4
+		case strings.HasPrefix(rest, "$$"):
+			n := strings.Index(rest[2:], "$$")
+			if n < 0 {
+				return nil, fmt.Errorf("unterminated $$ string at offset %d", i)
+			}
+			statement.WriteString(rest[:n+4])
+			i += n + 4
+		case rest[0] == '\'' || rest[0] == '"':
+			// quotes are escaped by doubling them, which reads as two adjacent quoted parts
+			n := strings.IndexByte(rest[1:], rest[0])
+			if n < 0 {
+				return nil, fmt.Errorf("unterminated %c quote at offset %d", rest[0], i)
+			}
+			statement.WriteString(rest[:n+2])
+			i += n + 2
+		case rest[0] == ';':
+			end()
+			i++
+		default:
+			statement.WriteByte(rest[0])
+			i++
+		}
+	}
+	end()
+
+	return statements, nil
+}
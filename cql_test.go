@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		expected []string
+	}{
+		{"empty", " \n\t", nil},
+		{"single without semicolon", "SELECT * FROM t", []string{"SELECT * FROM t"}},
+		{"several", "CREATE TABLE a (id int PRIMARY KEY);\nCREATE TABLE b (id int PRIMARY KEY);\n",
+			[]string{"CREATE TABLE a (id int PRIMARY KEY)", "CREATE TABLE b (id int PRIMARY KEY)"}},
+		{"empty statements", ";; SELECT 1 FROM t ;;", []string{"SELECT 1 FROM t"}},
+		{"semicolon in a string", "INSERT INTO t (s) VALUES ('a;b'); SELECT 1 FROM t",
+			[]string{"INSERT INTO t (s) VALUES ('a;b')", "SELECT 1 FROM t"}},
+		{"doubled quotes", "INSERT INTO t (s) VALUES ('it''s;ok');", []string{"INSERT INTO t (s) VALUES ('it''s;ok')"}},
+		{"semicolon in a quoted identifier", `ALTER TABLE t ADD "a;""b" int;`, []string{`ALTER TABLE t ADD "a;""b" int`}},
+		{"double quote in a string", `INSERT INTO t (s) VALUES ('say "hi;"');`, []string{`INSERT INTO t (s) VALUES ('say "hi;"')`}},
+		{"function body", "CREATE FUNCTION f (a int) RETURNS NULL ON NULL INPUT RETURNS int LANGUAGE java AS $$ return a; $$;",
+			[]string{"CREATE FUNCTION f (a int) RETURNS NULL ON NULL INPUT RETURNS int LANGUAGE java AS $$ return a; $$"}},
+		{"comment in a function body", "CREATE FUNCTION f () AS $$ // x; \n -- y; $$;",
+			[]string{"CREATE FUNCTION f () AS $$ // x; \n -- y; $$"}},
+		{"dash comment", "-- drop it; really\nDROP TABLE t;", []string{"DROP TABLE t"}},
+		{"slash comment", "DROP TABLE t; // done; bye", []string{"DROP TABLE t"}},
+		{"block comment", "/* first;\nsecond; */ DROP TABLE t;", []string{"DROP TABLE t"}},
+		{"comment inside a statement", "SELECT a /* ; */ FROM t -- ;\nWHERE a = 1;", []string{"SELECT a   FROM t  \nWHERE a = 1"}},
+		{"comment markers in a string", "INSERT INTO t (s) VALUES ('-- /* //');", []string{"INSERT INTO t (s) VALUES ('-- /* //')"}},
+		{"comment at the end without newline", "SELECT 1 FROM t; --", []string{"SELECT 1 FROM t"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statements, err := splitStatements(test.script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(statements, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, statements)
+			}
+		})
+	}
+}
+
+func TestSplitStatementsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		expected string
+	}{
+		{"unterminated string", "SELECT 1; INSERT INTO t (s) VALUES ('a;", "unterminated ' quote at offset 36"},
+		{"unterminated doubled quote", "INSERT INTO t (s) VALUES ('it''s", "unterminated ' quote at offset 30"},
+		{"unterminated identifier", `SELECT "a FROM t;`, `unterminated " quote at offset 7`},
+		{"unterminated comment", "SELECT 1 FROM t; /* ;", "unterminated comment at offset 17"},
+		{"unterminated function body", "CREATE FUNCTION f () AS $$ return 1;", "unterminated $$ string at offset 24"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := splitStatements(test.script)
+			if err == nil || err.Error() != test.expected {
+				t.Errorf("expected error %s, got %v", test.expected, err)
+			}
+		})
+	}
+}
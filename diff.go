@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/gocql/gocql"
+)
+
+// kinds of schema differences
+const (
+	diffMissingKeyspace = "missing_keyspace"
+	diffMissingTable    = "missing_table"
+	diffExtraTable      = "extra_table"
+	diffMissingColumn   = "missing_column"
+	diffExtraColumn     = "extra_column"
+	diffColumnType      = "column_type"
+	diffColumnKind      = "column_kind"
+	diffPrimaryKey      = "primary_key"
+	diffOption          = "option"
+)
+
+// schemaDifference is a difference between a source table and its target table. It is incompatible
+// when source rows can't be written as they are in the target table.
+type schemaDifference struct {
+	Kind         string `json:"kind"`
+	Table        string `json:"table,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Source       string `json:"source,omitempty"`
+	Target       string `json:"target,omitempty"`
+	Incompatible bool   `json:"incompatible"`
+}
+
+func (d *schemaDifference) String() string {
+	var s string
+	switch d.Kind {
+	case diffMissingKeyspace:
+		s = "keyspace is missing on target"
+	case diffMissingTable:
+		s = d.Table + ": table is missing on target"
+	case diffExtraTable:
+		s = d.Table + ": table only exists on target"
+	case diffMissingColumn:
+		s = d.Table + "." + d.Name + ": column " + d.Source + " is missing on target"
+	case diffExtraColumn:
+		s = d.Table + "." + d.Name + ": column " + d.Target + " only exists on target"
+	case diffColumnType:
+		s = d.Table + "." + d.Name + ": type " + d.Source + " on source, " + d.Target + " on target"
+	case diffColumnKind:
+		s = d.Table + "." + d.Name + ": " + d.Source + " column on source, " + d.Target + " on target"
+	case diffPrimaryKey:
+		s = d.Table + ": primary key " + d.Source + " on source, " + d.Target + " on target"
+	case diffOption:
+		s = d.Table + ": option " + d.Name + " = " + d.Source + " on source, " + d.Target + " on target"
+	}
+
+	if d.Incompatible {
+		return "[incompatible] " + s
+	}
+	return s
+}
+
+func isIncompatible(differences []*schemaDifference) bool {
+	for _, d := range differences {
+		if d.Incompatible {
+			return true
+		}
+	}
+
+	return false
+}
+
+// byte compatible types: the values of the source types are valid values of the target type, so
+// source cells can be written as they are
+var compatibleTypes = map[string][]string{
+	"text":      {"ascii"},
+	"uuid":      {"timeuuid"},
+	"varint":    {"tinyint", "smallint", "int", "bigint"},
+	"timestamp": {"bigint"},
+	"bigint":    {"timestamp"},
+}
+
+// parseCQLType splits a CQL type in its name and parameters, frozen is left out as frozen and non
+// frozen values are serialized the same way
+func parseCQLType(cqlType string) (string, []string) {
+	cqlType = strings.TrimSpace(cqlType)
+	for strings.HasPrefix(cqlType, "frozen<") && strings.HasSuffix(cqlType, ">") {
+		cqlType = strings.TrimSpace(cqlType[len("frozen<") : len(cqlType)-1])
+	}
+
+	i := strings.Index(cqlType, "<")
+	if i < 0 || !strings.HasSuffix(cqlType, ">") {
+		if cqlType == "varchar" {
+			return "text", nil
+		}
+		return cqlType, nil
+	}
+
+	return strings.TrimSpace(cqlType[:i]), splitTypeParameters(cqlType[i+1 : len(cqlType)-1])
+}
+
+// isCompatibleType tells if the values of a source column type can be written in a target column type
+func (c *Cassandra) isCompatibleType(sourceType string, targetType string) bool {
+	sourceName, sourceParams := parseCQLType(sourceType)
+	targetName, targetParams := parseCQLType(targetType)
+
+	if len(sourceParams) == 0 && len(targetParams) == 0 {
+		if sourceName == targetName || (targetName == "blob" && sourceName != "counter") {
+			return true
+		}
+
+		for _, t := range compatibleTypes[targetName] {
+			if t == sourceName {
+				return true
+			}
+		}
+		return false
+	}
+
+	if sourceName != targetName || len(sourceParams) != len(targetParams) {
+		return false
+	}
+
+	for i := range sourceParams {
+		if !c.isCompatibleType(sourceParams[i], targetParams[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getPrimaryKeyString renders the primary key of a table along with its clustering order
+func (c *Cassandra) getPrimaryKeyString(table *gocql.TableMetadata) string {
+	var pkColumns []string
+	for _, column := range table.PartitionKey {
+		pkColumns = append(pkColumns, quoteIdentifier(column.Name))
+	}
+
+	primaryKey := "((" + strings.Join(pkColumns, ", ") + ")"
+	for _, column := range table.ClusteringColumns {
+		primaryKey += ", " + quoteIdentifier(column.Name)
+		if column.Order == gocql.DESC {
+			primaryKey += " DESC"
+		}
+	}
+
+	return primaryKey + ")"
+}
+
+func (c *Cassandra) getColumnKindString(kind gocql.ColumnKind) string {
+	switch kind {
+	case gocql.ColumnPartitionKey:
+		return "partition key"
+	case gocql.ColumnClusteringKey:
+		return "clustering"
+	case gocql.ColumnStatic:
+		return "static"
+	default:
+		return "regular"
+	}
+}
+
+// getTableDifferences compares the columns, primary key and options of a source table with its target table
+func (c *Cassandra) getTableDifferences(source *gocql.TableMetadata, target *gocql.TableMetadata,
+	sourceOptions map[string]string, targetOptions map[string]string) []*schemaDifference {
+
+	var differences []*schemaDifference
+	if sourcePK, targetPK := c.getPrimaryKeyString(source), c.getPrimaryKeyString(target); sourcePK != targetPK {
+		differences = append(differences, &schemaDifference{Kind: diffPrimaryKey, Table: source.Name, Source: sourcePK,
+			Target: targetPK, Incompatible: true})
+	}
+
+	for _, name := range source.OrderedColumns {
+		column := source.Columns[name]
+		sourceType := c.getCQLType(column.Validator)
+		targetColumn, ok := target.Columns[name]
+		if !ok {
+			differences = append(differences, &schemaDifference{Kind: diffMissingColumn, Table: source.Name, Name: name,
+				Source: sourceType, Incompatible: true})
+			continue
+		}
+
+		targetType := c.getCQLType(targetColumn.Validator)
+		if sourceType != targetType {
+			differences = append(differences, &schemaDifference{Kind: diffColumnType, Table: source.Name, Name: name,
+				Source: sourceType, Target: targetType, Incompatible: !c.isCompatibleType(sourceType, targetType)})
+		}
+
+		if column.Kind == gocql.ColumnStatic || targetColumn.Kind == gocql.ColumnStatic {
+			if column.Kind != targetColumn.Kind {
+				differences = append(differences, &schemaDifference{Kind: diffColumnKind, Table: source.Name, Name: name,
+					Source: c.getColumnKindString(column.Kind), Target: c.getColumnKindString(targetColumn.Kind),
+					Incompatible: true})
+			}
+		}
+	}
+
+	for _, name := range target.OrderedColumns {
+		if _, ok := source.Columns[name]; !ok {
+			differences = append(differences, &schemaDifference{Kind: diffExtraColumn, Table: source.Name, Name: name,
+				Target: c.getCQLType(target.Columns[name].Validator)})
+		}
+	}
+
+	var options []string
+	for option := range sourceOptions {
+		options = append(options, option)
+	}
+	for option := range targetOptions {
+		if _, ok := sourceOptions[option]; !ok {
+			options = append(options, option)
+		}
+	}
+	sort.Strings(options)
+
+	for _, option := range options {
+		if sourceOptions[option] != targetOptions[option] {
+			differences = append(differences, &schemaDifference{Kind: diffOption, Table: source.Name,
+				Name: option, Source: sourceOptions[option], Target: targetOptions[option]})
+		}
+	}
+
+	return differences
+}
+
+// getSchemaDifferences compares the tables of two keyspaces, only table when it isn't empty
+func (c *Cassandra) getSchemaDifferences(s1 *gocql.Session, s2 *gocql.Session, fromKeyspace string, toKeyspace string,
+	table string) []*schemaDifference {
+
+	k1, err := s1.KeyspaceMetadata(fromKeyspace)
+	if err != nil {
+		panic(err)
+	}
+
+	k2, err := s2.KeyspaceMetadata(toKeyspace)
+	if err == gocql.ErrKeyspaceDoesNotExist {
+		return []*schemaDifference{{Kind: diffMissingKeyspace, Incompatible: true}}
+	} else if err != nil {
+		panic(err)
+	}
+
+	var names []string
+	for name := range k1.Tables {
+		if (table == "" || name == table) && !c.isView(k1, name) {
+			names = append(names, name)
+		}
+	}
+	for name := range k2.Tables {
+		if _, ok := k1.Tables[name]; !ok && table == "" && !c.isView(k2, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var differences []*schemaDifference
+	for _, name := range names {
+		source, inSource := k1.Tables[name]
+		target, inTarget := k2.Tables[name]
+		switch {
+		case !inTarget:
+			differences = append(differences, &schemaDifference{Kind: diffMissingTable, Table: name, Incompatible: true})
+		case !inSource:
+			differences = append(differences, &schemaDifference{Kind: diffExtraTable, Table: name})
+		default:
+			differences = append(differences, c.getTableDifferences(source, target,
+				c.getTableOptions(s1, fromKeyspace, name), c.getTableOptions(s2, toKeyspace, name))...)
+		}
+	}
+
+	return differences
+}
+
+// checkSchema aborts the transfer before any row is written when the target tables can't receive
+// the source rows, reporting all the incompatible differences
+func (c *Cassandra) checkSchema(s1 *gocql.Session, to ClusterOptions, fromKeyspace string, toKeyspace string,
+	table string) {
+
+	// a new session reads the target schema as it is now, the metadata cached by the transfer
+	// session may predate the tables creation
+	s2 := c.getCassandraSession(to)
+	defer s2.Close()
+
+	incompatible := 0
+	for _, d := range c.getSchemaDifferences(s1, s2, fromKeyspace, toKeyspace, table) {
+		if d.Incompatible {
+			log.Println(d.String())
+			incompatible++
+		}
+	}
+
+	if incompatible > 0 {
+		panic(fmt.Errorf("%d incompatible differences between %s and %s schemas, no row has been written",
+			incompatible, fromKeyspace, toKeyspace))
+	}
+
+	log.Println("Schema of " + toKeyspace + " is compatible with " + fromKeyspace)
+}
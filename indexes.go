@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/gocql/gocql"
+)
+
+// secondary indexes and materialized views are created once the base tables are loaded, so the
+// target doesn't have to maintain them for every written row. They are read from system_schema,
+// available from cassandra 3.0.
+
+type secondaryIndex struct {
+	name    string
+	table   string
+	kind    string
+	options map[string]string
+}
+
+// schema columns of system_schema.views which are not view options
+var ignoredViewOptions = map[string]bool{
+	"keyspace_name":       true,
+	"view_name":           true,
+	"base_table_id":       true,
+	"base_table_name":     true,
+	"where_clause":        true,
+	"include_all_columns": true,
+	"id":                  true,
+	"extensions":          true,
+}
+
+type viewColumn struct {
+	name            string
+	kind            string
+	position        int
+	clusteringOrder string
+}
+
+type materializedView struct {
+	name              string
+	baseTable         string
+	whereClause       string
+	includeAllColumns bool
+	columns           []*viewColumn
+	options           map[string]string
+}
+
+// isView tells if a table of the keyspace metadata is a materialized view, rows are never copied
+// into views as cassandra maintains them from their base table
+func (c *Cassandra) isView(k *gocql.KeyspaceMetadata, name string) bool {
+	_, ok := k.MaterializedViews[name]
+	return ok
+}
+
+func (c *Cassandra) getIndexes(s *gocql.Session, keyspace string) []*secondaryIndex {
+	iter := s.Query("SELECT table_name, index_name, kind, options FROM system_schema.indexes WHERE keyspace_name = ?",
+		keyspace).Iter()
+
+	var indexes []*secondaryIndex
+	i := &secondaryIndex{}
+	for iter.Scan(&i.table, &i.name, &i.kind, &i.options) {
+		indexes = append(indexes, i)
+		i = &secondaryIndex{}
+	}
+
+	if err := iter.Close(); err != nil {
+		log.Println("Unable to read " + keyspace + " indexes: " + err.Error())
+		return nil
+	}
+
+	return indexes
+}
+
+// getCreateIndexQuery builds the DDL of an index, custom ones (SASI, SAI...) keep their class and options
+func (c *Cassandra) getCreateIndexQuery(keyspace string, i *secondaryIndex) string {
+	if i.kind != "CUSTOM" {
+		return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s);", quoteIdentifier(i.name),
+			qualifiedName(keyspace, i.table), i.options["target"])
+	}
+
+	q := fmt.Sprintf("CREATE CUSTOM INDEX IF NOT EXISTS %s ON %s (%s) USING %s", quoteIdentifier(i.name),
+		qualifiedName(keyspace, i.table), i.options["target"], c.getStringOrNumber(i.options["class_name"]))
+
+	options := make(map[string]string)
+	for option, value := range i.options {
+		if option != "target" && option != "class_name" {
+			options[option] = value
+		}
+	}
+
+	if len(options) > 0 {
+		q += " WITH OPTIONS = " + c.getOptionValueString(options)
+	}
+
+	return q + ";"
+}
+
+func (c *Cassandra) getViewColumns(s *gocql.Session, keyspace string, view string) ([]*viewColumn, error) {
+	iter := s.Query(`SELECT column_name, kind, position, clustering_order FROM system_schema.columns
+		WHERE keyspace_name = ? AND table_name = ?`, keyspace, view).Iter()
+
+	var columns []*viewColumn
+	column := &viewColumn{}
+	for iter.Scan(&column.name, &column.kind, &column.position, &column.clusteringOrder) {
+		columns = append(columns, column)
+		column = &viewColumn{}
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	// key columns in their declaration order, then the other ones by name
+	rank := func(column *viewColumn) int {
+		switch column.kind {
+		case "partition_key":
+			return 0
+		case "clustering":
+			return 1
+		}
+		return 2
+	}
+
+	sort.SliceStable(columns, func(i, j int) bool {
+		ri, rj := rank(columns[i]), rank(columns[j])
+		if ri != rj {
+			return ri < rj
+		}
+		if ri < 2 {
+			return columns[i].position < columns[j].position
+		}
+		return columns[i].name < columns[j].name
+	})
+
+	return columns, nil
+}
+
+func (c *Cassandra) getMaterializedViews(s *gocql.Session, keyspace string) []*materializedView {
+	iter := s.Query("SELECT * FROM system_schema.views WHERE keyspace_name = ?", keyspace).Iter()
+
+	var views []*materializedView
+	for {
+		row := make(map[string]interface{})
+		if !iter.MapScan(row) {
+			break
+		}
+
+		v := &materializedView{options: c.getSchemaOptions(row, ignoredViewOptions)}
+		v.name, _ = row["view_name"].(string)
+		v.baseTable, _ = row["base_table_name"].(string)
+		v.whereClause, _ = row["where_clause"].(string)
+		v.includeAllColumns, _ = row["include_all_columns"].(bool)
+		views = append(views, v)
+	}
+
+	if err := iter.Close(); err != nil {
+		log.Println("Unable to read " + keyspace + " materialized views: " + err.Error())
+		return nil
+	}
+
+	for _, v := range views {
+		columns, err := c.getViewColumns(s, keyspace, v.name)
+		if err != nil {
+			panic(err)
+		}
+		v.columns = columns
+	}
+
+	return views
+}
+
+func (c *Cassandra) getCreateViewQuery(keyspace string, v *materializedView) string {
+	var columns []string
+	var pkColumns []string
+	var clusteringColumns []string
+	var clusteringOrder []string
+	for _, column := range v.columns {
+		name := quoteIdentifier(column.name)
+		columns = append(columns, name)
+
+		switch column.kind {
+		case "partition_key":
+			pkColumns = append(pkColumns, name)
+		case "clustering":
+			clusteringColumns = append(clusteringColumns, name)
+			clusteringOrder = append(clusteringOrder, name+" "+strings.ToUpper(column.clusteringOrder))
+		}
+	}
+
+	selected := strings.Join(columns, ", ")
+	if v.includeAllColumns {
+		selected = "*"
+	}
+
+	primaryKey := "(" + strings.Join(pkColumns, ", ") + ")"
+	if len(clusteringColumns) > 0 {
+		primaryKey += ", " + strings.Join(clusteringColumns, ", ")
+	}
+
+	var with []string
+	if len(clusteringOrder) > 0 {
+		with = append(with, "CLUSTERING ORDER BY ("+strings.Join(clusteringOrder, ", ")+")")
+	}
+	with = append(with, c.getTableOptionsString(v.options)...)
+
+	q := fmt.Sprintf("CREATE MATERIALIZED VIEW IF NOT EXISTS %s AS SELECT %s FROM %s WHERE %s PRIMARY KEY (%s)",
+		qualifiedName(keyspace, v.name), selected, qualifiedName(keyspace, v.baseTable), v.whereClause, primaryKey)
+	if len(with) > 0 {
+		q += " WITH " + strings.Join(with, " AND ")
+	}
+
+	return q + ";"
+}
+
+// getIndexesAndViewsQueries returns the DDL of the indexes and materialized views of the source
+// keyspace to run in the target one, only the ones of table when it isn't empty. Only the view options
+// in knownViewOptions are kept, all of them when it is nil.
+func (c *Cassandra) getIndexesAndViewsQueries(s *gocql.Session, fromKeyspace string, toKeyspace string,
+	table string, knownViewOptions map[string]bool) []string {
+
+	var queries []string
+	for _, i := range c.getIndexes(s, fromKeyspace) {
+		if table == "" || i.table == table {
+			queries = append(queries, c.getCreateIndexQuery(toKeyspace, i))
+		}
+	}
+
+	for _, v := range c.getMaterializedViews(s, fromKeyspace) {
+		if table == "" || v.baseTable == table {
+			v.options = c.filterOptions(toKeyspace+"."+v.name, v.options, knownViewOptions)
+			queries = append(queries, c.getCreateViewQuery(toKeyspace, v))
+		}
+	}
+
+	return queries
+}
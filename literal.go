@@ -0,0 +1,470 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// CQL literals are built from the serialized values and the type info the driver returns with
+// each result, which are the same for every cassandra version. Vectors (cassandra 5.0+) are
+// unknown to the driver and reach us as a custom type.
+
+const vectorClass = marshalPackage + "VectorType("
+
+// vector element classes serialized with a fixed size, the other ones are prefixed by their length
+var fixedSizeClasses = map[string]int{
+	"BooleanType":   1,
+	"FloatType":     4,
+	"Int32Type":     4,
+	"DoubleType":    8,
+	"LongType":      8,
+	"TimestampType": 8,
+	"DateType":      8,
+	"UUIDType":      16,
+	"TimeUUIDType":  16,
+}
+
+// native types of the vector element classes
+var classTypes = map[string]gocql.Type{
+	"AsciiType":       gocql.TypeAscii,
+	"BooleanType":     gocql.TypeBoolean,
+	"ByteType":        gocql.TypeTinyInt,
+	"BytesType":       gocql.TypeBlob,
+	"DateType":        gocql.TypeTimestamp,
+	"DecimalType":     gocql.TypeDecimal,
+	"DoubleType":      gocql.TypeDouble,
+	"DurationType":    gocql.TypeDuration,
+	"FloatType":       gocql.TypeFloat,
+	"InetAddressType": gocql.TypeInet,
+	"Int32Type":       gocql.TypeInt,
+	"IntegerType":     gocql.TypeVarint,
+	"LongType":        gocql.TypeBigInt,
+	"ShortType":       gocql.TypeSmallInt,
+	"SimpleDateType":  gocql.TypeDate,
+	"TimeType":        gocql.TypeTime,
+	"TimeUUIDType":    gocql.TypeTimeUUID,
+	"TimestampType":   gocql.TypeTimestamp,
+	"UTF8Type":        gocql.TypeVarchar,
+	"UUIDType":        gocql.TypeUUID,
+}
+
+// readSize reads a collection size or an element length, on 2 bytes before protocol v3
+func readSize(data []byte, proto byte) (int, []byte, error) {
+	if proto < 3 {
+		if len(data) < 2 {
+			return 0, nil, fmt.Errorf("truncated value")
+		}
+		return int(binary.BigEndian.Uint16(data)), data[2:], nil
+	}
+
+	if len(data) < 4 {
+		return 0, nil, fmt.Errorf("truncated value")
+	}
+	return int(int32(binary.BigEndian.Uint32(data))), data[4:], nil
+}
+
+// readElement reads a length prefixed element, a negative length is a null element
+func readElement(data []byte, proto byte) ([]byte, []byte, error) {
+	size, data, err := readSize(data, proto)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if size < 0 {
+		return nil, data, nil
+	}
+
+	if len(data) < size {
+		return nil, nil, fmt.Errorf("truncated value")
+	}
+	return data[:size:size], data[size:], nil
+}
+
+// readUnsignedVint reads a cassandra variable length integer, the number of leading one bits of
+// the first byte is the number of extra bytes
+func readUnsignedVint(data []byte) (uint64, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, fmt.Errorf("truncated value")
+	}
+
+	extra := 0
+	for extra < 8 && data[0]&(0x80>>uint(extra)) != 0 {
+		extra++
+	}
+
+	if len(data) < extra+1 {
+		return 0, nil, fmt.Errorf("truncated value")
+	}
+
+	value := uint64(data[0] & (0xFF >> uint(extra)))
+	for i := 1; i <= extra; i++ {
+		value = value<<8 | uint64(data[i])
+	}
+
+	return value, data[extra+1:], nil
+}
+
+func readVint(data []byte) (int64, []byte, error) {
+	value, data, err := readUnsignedVint(data)
+	// zigzag encoding
+	return int64(value>>1) ^ -int64(value&1), data, err
+}
+
+func decodeVarint(data []byte) *big.Int {
+	n := new(big.Int).SetBytes(data)
+	if len(data) > 0 && data[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(8*len(data))))
+	}
+
+	return n
+}
+
+func formatFloat(f float64, bits int) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, bits)
+}
+
+func formatDecimal(data []byte) (string, error) {
+	if len(data) < 4 {
+		return "", fmt.Errorf("truncated decimal")
+	}
+
+	scale := int(int32(binary.BigEndian.Uint32(data)))
+	unscaled := decodeVarint(data[4:])
+	if scale < 0 {
+		return unscaled.String() + "E" + strconv.Itoa(-scale), nil
+	}
+
+	digits := new(big.Int).Abs(unscaled).String()
+	if scale == 0 {
+		return unscaled.String(), nil
+	}
+
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+
+	sign := ""
+	if unscaled.Sign() < 0 {
+		sign = "-"
+	}
+
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:], nil
+}
+
+// formatDuration renders a duration as months, days and nanoseconds, which all have the same sign
+func formatDuration(data []byte) (string, error) {
+	months, data, err := readVint(data)
+	if err != nil {
+		return "", err
+	}
+
+	days, data, err := readVint(data)
+	if err != nil {
+		return "", err
+	}
+
+	nanoseconds, _, err := readVint(data)
+	if err != nil {
+		return "", err
+	}
+
+	sign := ""
+	if months < 0 || days < 0 || nanoseconds < 0 {
+		sign = "-"
+	}
+
+	abs := func(v int64) string {
+		if v < 0 {
+			v = -v
+		}
+		return strconv.FormatInt(v, 10)
+	}
+
+	var literal string
+	if months != 0 {
+		literal += abs(months) + "mo"
+	}
+	if days != 0 {
+		literal += abs(days) + "d"
+	}
+	if nanoseconds != 0 || literal == "" {
+		literal += abs(nanoseconds) + "ns"
+	}
+
+	return sign + literal, nil
+}
+
+// getCQLLiteral renders a serialized value of a given type as a CQL literal
+func (c *Cassandra) getCQLLiteral(info gocql.TypeInfo, data []byte) (string, error) {
+	if data == nil {
+		return "null", nil
+	}
+
+	switch info.Type() {
+	case gocql.TypeAscii, gocql.TypeText, gocql.TypeVarchar:
+		return c.getStringOrNumber(string(data)), nil
+	case gocql.TypeBlob:
+		return "0x" + hex.EncodeToString(data), nil
+	case gocql.TypeCustom:
+		return c.getCustomLiteral(info, data)
+	}
+
+	// empty values of the other types can't be written as literals
+	if len(data) == 0 {
+		if info.Type() == gocql.TypeUDT {
+			return "{}", nil
+		}
+		return "blobAs" + info.Type().String() + "(0x)", nil
+	}
+
+	switch info.Type() {
+	case gocql.TypeBoolean:
+		return strconv.FormatBool(data[0] != 0), nil
+	case gocql.TypeTinyInt, gocql.TypeSmallInt, gocql.TypeInt, gocql.TypeBigInt, gocql.TypeCounter, gocql.TypeVarint:
+		return decodeVarint(data).String(), nil
+	case gocql.TypeFloat:
+		if len(data) != 4 {
+			return "", fmt.Errorf("invalid float size %d", len(data))
+		}
+		return formatFloat(float64(math.Float32frombits(binary.BigEndian.Uint32(data))), 32), nil
+	case gocql.TypeDouble:
+		if len(data) != 8 {
+			return "", fmt.Errorf("invalid double size %d", len(data))
+		}
+		return formatFloat(math.Float64frombits(binary.BigEndian.Uint64(data)), 64), nil
+	case gocql.TypeDecimal:
+		return formatDecimal(data)
+	case gocql.TypeUUID, gocql.TypeTimeUUID:
+		u, err := gocql.UUIDFromBytes(data)
+		if err != nil {
+			return "", err
+		}
+		return u.String(), nil
+	case gocql.TypeInet:
+		if len(data) != 4 && len(data) != 16 {
+			return "", fmt.Errorf("invalid inet size %d", len(data))
+		}
+		return c.getStringOrNumber(net.IP(data).String()), nil
+	case gocql.TypeTimestamp:
+		if len(data) != 8 {
+			return "", fmt.Errorf("invalid timestamp size %d", len(data))
+		}
+		return c.getTimestampLiteral(int64(binary.BigEndian.Uint64(data))), nil
+	case gocql.TypeDate:
+		if len(data) != 4 {
+			return "", fmt.Errorf("invalid date size %d", len(data))
+		}
+		return c.getDateLiteral(binary.BigEndian.Uint32(data)), nil
+	case gocql.TypeTime:
+		if len(data) != 8 {
+			return "", fmt.Errorf("invalid time size %d", len(data))
+		}
+		return c.getTimeLiteral(int64(binary.BigEndian.Uint64(data))), nil
+	case gocql.TypeDuration:
+		return formatDuration(data)
+	case gocql.TypeList, gocql.TypeSet, gocql.TypeMap:
+		return c.getCollectionLiteral(info.(gocql.CollectionType), data)
+	case gocql.TypeTuple:
+		return c.getTupleLiteral(info.(gocql.TupleTypeInfo), data)
+	case gocql.TypeUDT:
+		return c.getUDTLiteral(info.(gocql.UDTTypeInfo), data)
+	default:
+		return "", fmt.Errorf("unsupported type %s", info.Type().String())
+	}
+}
+
+// getRawValueLiteral renders a cell as a CQL literal, or as a blob when its bytes can't be decoded
+func (c *Cassandra) getRawValueLiteral(v *rawValue) string {
+	literal, err := c.getCQLLiteral(v.info, v.data)
+	if err != nil {
+		return "0x" + hex.EncodeToString(v.data)
+	}
+
+	return literal
+}
+
+// getTimestampLiteral renders milliseconds since epoch in UTC, as a number out of the years 1 to 9999
+func (c *Cassandra) getTimestampLiteral(milliseconds int64) string {
+	t := time.Unix(milliseconds/1000, (milliseconds%1000)*int64(time.Millisecond)).UTC()
+	if t.Year() < 1 || t.Year() > 9999 {
+		return strconv.FormatInt(milliseconds, 10)
+	}
+
+	return c.getStringOrNumber(t)
+}
+
+// getDateLiteral renders days since epoch shifted by 2^31, as a number out of the years 1 to 9999
+func (c *Cassandra) getDateLiteral(days uint32) string {
+	t := time.Unix((int64(days)-1<<31)*24*60*60, 0).UTC()
+	if t.Year() < 1 || t.Year() > 9999 {
+		return c.getStringOrNumber(strconv.FormatUint(uint64(days), 10))
+	}
+
+	return c.getStringOrNumber(t.Format("2006-01-02"))
+}
+
+// getTimeLiteral renders nanoseconds since midnight
+func (c *Cassandra) getTimeLiteral(nanoseconds int64) string {
+	d := time.Duration(nanoseconds)
+	return c.getStringOrNumber(fmt.Sprintf("%02d:%02d:%02d.%09d", int64(d/time.Hour), int64(d%time.Hour/time.Minute),
+		int64(d%time.Minute/time.Second), int64(d%time.Second)))
+}
+
+func (c *Cassandra) getCollectionLiteral(info gocql.CollectionType, data []byte) (string, error) {
+	proto := info.Version()
+	count, data, err := readSize(data, proto)
+	if err != nil {
+		return "", err
+	}
+
+	var elements []string
+	for i := 0; i < count; i++ {
+		var key, value []byte
+		if info.Type() == gocql.TypeMap {
+			if key, data, err = readElement(data, proto); err != nil {
+				return "", err
+			}
+		}
+
+		if value, data, err = readElement(data, proto); err != nil {
+			return "", err
+		}
+
+		element, err := c.getCQLLiteral(info.Elem, value)
+		if err != nil {
+			return "", err
+		}
+
+		if info.Type() == gocql.TypeMap {
+			k, err := c.getCQLLiteral(info.Key, key)
+			if err != nil {
+				return "", err
+			}
+			element = k + ": " + element
+		}
+
+		elements = append(elements, element)
+	}
+
+	if info.Type() == gocql.TypeList {
+		return "[" + strings.Join(elements, ", ") + "]", nil
+	}
+	return "{" + strings.Join(elements, ", ") + "}", nil
+}
+
+func (c *Cassandra) getTupleLiteral(info gocql.TupleTypeInfo, data []byte) (string, error) {
+	var elements []string
+	for _, elem := range info.Elems {
+		var value []byte
+		var err error
+		if len(data) > 0 {
+			// tuple elements sizes are always 4 bytes long
+			if value, data, err = readElement(data, 3); err != nil {
+				return "", err
+			}
+		}
+
+		element, err := c.getCQLLiteral(elem, value)
+		if err != nil {
+			return "", err
+		}
+		elements = append(elements, element)
+	}
+
+	return "(" + strings.Join(elements, ", ") + ")", nil
+}
+
+// getUDTLiteral renders a user type value, fields added to the type after the value was written are null
+func (c *Cassandra) getUDTLiteral(info gocql.UDTTypeInfo, data []byte) (string, error) {
+	var fields []string
+	for _, field := range info.Elements {
+		if len(data) == 0 {
+			break
+		}
+
+		var value []byte
+		var err error
+		if value, data, err = readElement(data, 3); err != nil {
+			return "", err
+		}
+
+		element, err := c.getCQLLiteral(field.Type, value)
+		if err != nil {
+			return "", err
+		}
+		fields = append(fields, quoteIdentifier(field.Name)+": "+element)
+	}
+
+	return "{" + strings.Join(fields, ", ") + "}", nil
+}
+
+// getCustomLiteral renders vectors as lists, other custom values are left as blobs
+func (c *Cassandra) getCustomLiteral(info gocql.TypeInfo, data []byte) (string, error) {
+	class := info.Custom()
+	if !strings.HasPrefix(class, vectorClass) || !strings.HasSuffix(class, ")") {
+		return "0x" + hex.EncodeToString(data), nil
+	}
+
+	// VectorType(element class,dimension)
+	params := splitTypeParameters(class[len(vectorClass) : len(class)-1])
+	if len(params) != 2 {
+		return "", fmt.Errorf("invalid vector type %s", class)
+	}
+
+	dimension, err := strconv.Atoi(params[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid vector type %s", class)
+	}
+
+	elementClass := strings.TrimPrefix(params[0], marshalPackage)
+	var elementInfo gocql.TypeInfo = gocql.NewNativeType(info.Version(), gocql.TypeCustom, params[0])
+	if t, ok := classTypes[elementClass]; ok {
+		elementInfo = gocql.NewNativeType(info.Version(), t, "")
+	}
+
+	var elements []string
+	for i := 0; i < dimension; i++ {
+		var value []byte
+		if size, ok := fixedSizeClasses[elementClass]; ok {
+			if len(data) < size {
+				return "", fmt.Errorf("truncated vector")
+			}
+			value, data = data[:size], data[size:]
+		} else {
+			size, rest, err := readUnsignedVint(data)
+			if err != nil {
+				return "", err
+			}
+			if uint64(len(rest)) < size {
+				return "", fmt.Errorf("truncated vector")
+			}
+			value, data = rest[:size], rest[size:]
+		}
+
+		element, err := c.getCQLLiteral(elementInfo, value)
+		if err != nil {
+			return "", err
+		}
+		elements = append(elements, element)
+	}
+
+	return "[" + strings.Join(elements, ", ") + "]", nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"gopkg.in/inf.v0"
+)
+
+const testProto = 4
+
+func nativeType(t gocql.Type) gocql.NativeType {
+	return gocql.NewNativeType(testProto, t, "")
+}
+
+func collectionType(t gocql.Type, key gocql.TypeInfo, elem gocql.TypeInfo) gocql.TypeInfo {
+	return gocql.CollectionType{NativeType: nativeType(t), Key: key, Elem: elem}
+}
+
+func mustMarshal(t *testing.T, info gocql.TypeInfo, value interface{}) []byte {
+	data, err := gocql.Marshal(info, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func mustParseUUID(t *testing.T, s string) gocql.UUID {
+	u, err := gocql.ParseUUID(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u
+}
+
+type literalTest struct {
+	name     string
+	info     gocql.TypeInfo
+	value    interface{}
+	expected string
+}
+
+// getLiteralTests returns one value per type along with its CQL literal
+func getLiteralTests(t *testing.T) []literalTest {
+	varint, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	listOfInts := collectionType(gocql.TypeList, nil, nativeType(gocql.TypeInt))
+
+	return []literalTest{
+		{"ascii", nativeType(gocql.TypeAscii), "it's", "'it''s'"},
+		{"text", nativeType(gocql.TypeVarchar), "héllo", "'héllo'"},
+		{"empty text", nativeType(gocql.TypeVarchar), "", "''"},
+		{"boolean", nativeType(gocql.TypeBoolean), true, "true"},
+		{"false", nativeType(gocql.TypeBoolean), false, "false"},
+		{"tinyint", nativeType(gocql.TypeTinyInt), int8(-128), "-128"},
+		{"smallint", nativeType(gocql.TypeSmallInt), int16(32767), "32767"},
+		{"int", nativeType(gocql.TypeInt), int32(-42), "-42"},
+		{"bigint", nativeType(gocql.TypeBigInt), int64(math.MinInt64), "-9223372036854775808"},
+		{"counter", nativeType(gocql.TypeCounter), int64(7), "7"},
+		{"varint", nativeType(gocql.TypeVarint), varint, "-123456789012345678901234567890"},
+		{"float", nativeType(gocql.TypeFloat), float32(1.1), "1.1"},
+		{"float NaN", nativeType(gocql.TypeFloat), float32(math.NaN()), "NaN"},
+		{"float -Infinity", nativeType(gocql.TypeFloat), float32(math.Inf(-1)), "-Infinity"},
+		{"double", nativeType(gocql.TypeDouble), 0.1, "0.1"},
+		{"double NaN", nativeType(gocql.TypeDouble), math.NaN(), "NaN"},
+		{"double Infinity", nativeType(gocql.TypeDouble), math.Inf(1), "Infinity"},
+		{"decimal", nativeType(gocql.TypeDecimal), inf.NewDec(-12345, 2), "-123.45"},
+		{"decimal lower than one", nativeType(gocql.TypeDecimal), inf.NewDec(5, 3), "0.005"},
+		{"decimal negative scale", nativeType(gocql.TypeDecimal), inf.NewDec(12, -3), "12E3"},
+		{"uuid", nativeType(gocql.TypeUUID), mustParseUUID(t, "550e8400-e29b-41d4-a716-446655440000"),
+			"550e8400-e29b-41d4-a716-446655440000"},
+		{"timeuuid", nativeType(gocql.TypeTimeUUID), mustParseUUID(t, "d2177dd0-eaa2-11de-a572-001b779c76e3"),
+			"d2177dd0-eaa2-11de-a572-001b779c76e3"},
+		{"inet v4", nativeType(gocql.TypeInet), net.ParseIP("192.168.1.1"), "'192.168.1.1'"},
+		{"inet v6", nativeType(gocql.TypeInet), net.ParseIP("2001:db8::1"), "'2001:db8::1'"},
+		{"blob", nativeType(gocql.TypeBlob), []byte{0xca, 0xfe}, "0xcafe"},
+		{"empty blob", nativeType(gocql.TypeBlob), []byte{}, "0x"},
+		{"timestamp", nativeType(gocql.TypeTimestamp), time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.FixedZone("", 3600)),
+			"'2020-01-02 02:04:05.006+0000'"},
+		{"timestamp before epoch", nativeType(gocql.TypeTimestamp), int64(-1), "'1969-12-31 23:59:59.999+0000'"},
+		{"timestamp after year 9999", nativeType(gocql.TypeTimestamp), int64(253402300800000), "253402300800000"},
+		{"timestamp before year 1", nativeType(gocql.TypeTimestamp), int64(-62135596800001), "-62135596800001"},
+		{"date", nativeType(gocql.TypeDate), time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC), "'2020-02-29'"},
+		{"date year 1", nativeType(gocql.TypeDate), time.Date(1, 1, 2, 0, 0, 0, 0, time.UTC), "'0001-01-02'"},
+		{"date year 9999", nativeType(gocql.TypeDate), time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC), "'9999-12-31'"},
+		{"date after year 9999", nativeType(gocql.TypeDate), int64(3000000) * 86400000, "'2150483648'"},
+		{"date before year 1", nativeType(gocql.TypeDate), int64(-3000000) * 86400000, "'2144483648'"},
+		{"time", nativeType(gocql.TypeTime), 3*time.Hour + 4*time.Minute + 5*time.Second + 6, "'03:04:05.000000006'"},
+		{"time end of day", nativeType(gocql.TypeTime), 24*time.Hour - 1, "'23:59:59.999999999'"},
+		{"duration", nativeType(gocql.TypeDuration), gocql.Duration{Months: 14, Days: 3, Nanoseconds: 5000000000},
+			"14mo3d5000000000ns"},
+		{"negative duration", nativeType(gocql.TypeDuration), gocql.Duration{Months: -1, Days: -2, Nanoseconds: -3}, "-1mo2d3ns"},
+		{"zero duration", nativeType(gocql.TypeDuration), gocql.Duration{}, "0ns"},
+		{"list", listOfInts, []int{1, 2, 3}, "[1, 2, 3]"},
+		{"empty list", listOfInts, []int{}, "[]"},
+		{"set", collectionType(gocql.TypeSet, nil, nativeType(gocql.TypeVarchar)), []string{"a", "b'c"}, "{'a', 'b''c'}"},
+		{"map", collectionType(gocql.TypeMap, nativeType(gocql.TypeVarchar), nativeType(gocql.TypeBigInt)),
+			map[string]int64{"a": 1}, "{'a': 1}"},
+		{"nested collections", collectionType(gocql.TypeMap, nativeType(gocql.TypeInt),
+			collectionType(gocql.TypeList, nil, collectionType(gocql.TypeSet, nil, nativeType(gocql.TypeVarchar)))),
+			map[int][][]string{1: {{"x"}, {}}}, "{1: [{'x'}, {}]}"},
+	}
+}
+
+func TestGetCQLLiteral(t *testing.T) {
+	c := Cassandra{}
+	for _, test := range getLiteralTests(t) {
+		t.Run(test.name, func(t *testing.T) {
+			literal, err := c.getCQLLiteral(test.info, mustMarshal(t, test.info, test.value))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if literal != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, literal)
+			}
+		})
+	}
+}
+
+func TestGetCQLLiteralNullAndEmpty(t *testing.T) {
+	c := Cassandra{}
+	if literal, err := c.getCQLLiteral(nativeType(gocql.TypeInt), nil); err != nil || literal != "null" {
+		t.Errorf("expected null, got %s %v", literal, err)
+	}
+
+	if literal, err := c.getCQLLiteral(nativeType(gocql.TypeInt), []byte{}); err != nil || literal != "blobAsint(0x)" {
+		t.Errorf("expected blobAsint(0x), got %s %v", literal, err)
+	}
+}
+
+func TestGetTupleLiteral(t *testing.T) {
+	c := Cassandra{}
+	info := gocql.TupleTypeInfo{
+		NativeType: nativeType(gocql.TypeTuple),
+		Elems:      []gocql.TypeInfo{nativeType(gocql.TypeInt), nativeType(gocql.TypeVarchar), nativeType(gocql.TypeBoolean)},
+	}
+
+	data := mustMarshal(t, info, []interface{}{1, "a", true})
+	if literal, err := c.getCQLLiteral(info, data); err != nil || literal != "(1, 'a', true)" {
+		t.Errorf("expected (1, 'a', true), got %s %v", literal, err)
+	}
+
+	// the driver marshals nil elements as empty values, a null element has a negative length
+	var withNull []byte
+	withNull = append(withNull, 0, 0, 0, 4, 0, 0, 0, 1)
+	withNull = append(withNull, 0xFF, 0xFF, 0xFF, 0xFF)
+	withNull = append(withNull, 0, 0, 0, 1, 1)
+	if literal, err := c.getCQLLiteral(info, withNull); err != nil || literal != "(1, null, true)" {
+		t.Errorf("expected (1, null, true), got %s %v", literal, err)
+	}
+}
+
+func TestGetUDTLiteral(t *testing.T) {
+	c := Cassandra{}
+	written := gocql.UDTTypeInfo{
+		NativeType: nativeType(gocql.TypeUDT),
+		Name:       "address",
+		Elements: []gocql.UDTField{
+			{Name: "Street", Type: nativeType(gocql.TypeVarchar)},
+			{Name: "number", Type: nativeType(gocql.TypeInt)},
+		},
+	}
+
+	// a field added to the type after the value was written
+	read := written
+	read.Elements = append(append([]gocql.UDTField{}, written.Elements...),
+		gocql.UDTField{Name: "zip", Type: nativeType(gocql.TypeVarchar)})
+
+	data := mustMarshal(t, written, map[string]interface{}{"Street": "main", "number": 12})
+	expected := `{"Street": 'main', number: 12}`
+	if literal, err := c.getCQLLiteral(read, data); err != nil || literal != expected {
+		t.Errorf("expected %s, got %s %v", expected, literal, err)
+	}
+}
+
+func TestGetVectorLiteral(t *testing.T) {
+	c := Cassandra{}
+
+	floats := gocql.NewNativeType(testProto, gocql.TypeCustom, vectorClass+marshalPackage+"FloatType,3)")
+	var data []byte
+	for _, f := range []float32{1.5, -2, 0.25} {
+		data = append(data, mustMarshal(t, nativeType(gocql.TypeFloat), f)...)
+	}
+	if literal, err := c.getCQLLiteral(floats, data); err != nil || literal != "[1.5, -2, 0.25]" {
+		t.Errorf("expected [1.5, -2, 0.25], got %s %v", literal, err)
+	}
+
+	// variable size elements are prefixed by their length as an unsigned vint
+	texts := gocql.NewNativeType(testProto, gocql.TypeCustom, vectorClass+marshalPackage+"UTF8Type,2)")
+	long := strings.Repeat("x", 200)
+	data = []byte{1, 'a', 0x80, 200}
+	data = append(data, long...)
+	expected := "['a', '" + long + "']"
+	if literal, err := c.getCQLLiteral(texts, data); err != nil || literal != expected {
+		t.Errorf("expected %s, got %s %v", expected, literal, err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var MigrationsDir = "migrations"
+var MigrationsLockTTL = 10 * time.Minute
+
+var migrationName = regexp.MustCompile(`[^a-z0-9_]+`)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate [COMMANDS]",
+	Short: "apply versioned CQL migrations to a keyspace",
+}
+
+// migratePreRun checks the target cluster flags, statements of the migrations may use unqualified
+// table names of the keyspace
+func migratePreRun(cmd *cobra.Command, args []string) error {
+	if To.Host == "" {
+		return fmt.Errorf("TO host is mandatory")
+	}
+
+	if ToKeyspace == "" {
+		return fmt.Errorf("TO keyspace is mandatory")
+	}
+
+	if MigrationsLockTTL < time.Second {
+		return fmt.Errorf("lock ttl must be at least 1s")
+	}
+
+	To.Keyspace = ToKeyspace
+	return To.resolve("to")
+}
+
+func newMigrator() *Migrator {
+	c := Cassandra{}
+	m, err := NewMigrator(c.getCassandraSession(To), ToKeyspace, MigrationsDir, MigrationsLockTTL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return m
+}
+
+// countArg parses the optional migration count argument
+func countArg(args []string, defaultCount int) int {
+	if len(args) == 0 {
+		return defaultCount
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		log.Fatal(fmt.Errorf("invalid migration count %s", args[0]))
+	}
+
+	return n
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:     "up [N]",
+	Short:   "apply all the pending migrations, or the N next ones",
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: migratePreRun,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := newMigrator().Up(countArg(args, 0)); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:     "down [N]",
+	Short:   "revert the last applied migration, or the N last ones",
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: migratePreRun,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := newMigrator().Down(countArg(args, 1)); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:     "status",
+	Short:   "list the migrations and whether they are applied",
+	PreRunE: migratePreRun,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := newMigrator().Status(); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var migrateForceCmd = &cobra.Command{
+	Use:     "force VERSION",
+	Short:   "record the migrations up to VERSION as applied without running them, 0 for none",
+	Args:    cobra.ExactArgs(1),
+	PreRunE: migratePreRun,
+	Run: func(cmd *cobra.Command, args []string) {
+		version, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || version < 0 {
+			log.Fatal(fmt.Errorf("invalid migration version %s", args[0]))
+		}
+
+		if err := newMigrator().Force(version); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var migrateCreateCmd = &cobra.Command{
+	Use:   "create NAME",
+	Short: "create the up and down scripts of a new migration",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := strings.Trim(migrationName.ReplaceAllString(strings.ToLower(args[0]), "_"), "_")
+		if name == "" {
+			log.Fatal(fmt.Errorf("invalid migration name %s", args[0]))
+		}
+
+		paths, err := createMigrationFiles(MigrationsDir, name)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, path := range paths {
+			fmt.Println(path)
+		}
+	},
+}
+
+func init() {
+	migrateCmd.PersistentFlags().StringVarP(&MigrationsDir, "dir", "d", MigrationsDir, "directory of the <version>_<name>.up.cql and .down.cql migration files")
+	migrateCmd.PersistentFlags().StringVarP(&To.Host, "to-host", "t", To.Host, "cassandra3:9042,cassandra4:9042")
+	migrateCmd.PersistentFlags().StringVarP(&ToKeyspace, "to-keyspace", "o", ToKeyspace, "keyspace_name")
+	migrateCmd.PersistentFlags().DurationVar(&MigrationsLockTTL, "lock-ttl", MigrationsLockTTL, "expiration of the migration lock if the process dies while holding it")
+	addClusterFlags(migrateCmd.PersistentFlags(), "to", &To)
+
+	migrateCmd.AddCommand(migrateUpCmd)
+	migrateCmd.AddCommand(migrateDownCmd)
+	migrateCmd.AddCommand(migrateStatusCmd)
+	migrateCmd.AddCommand(migrateForceCmd)
+	migrateCmd.AddCommand(migrateCreateCmd)
+	rootCmd.AddCommand(migrateCmd)
+}
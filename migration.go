@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// migration files are named <version>_<name>.up.cql and <version>_<name>.down.cql
+var migrationFileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.cql$`)
+
+type migration struct {
+	version  int64
+	name     string
+	upPath   string
+	downPath string
+}
+
+// checksum is the hash of the up script, an applied migration must not be edited afterwards
+func (m *migration) checksum() (string, error) {
+	data, err := ioutil.ReadFile(m.upPath)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (m *migration) String() string {
+	return strconv.FormatInt(m.version, 10) + "_" + m.name
+}
+
+// loadMigrations reads the migrations of a directory sorted by version
+func loadMigrations(dir string) ([]*migration, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*migration)
+	for _, f := range files {
+		match := migrationFileName.FindStringSubmatch(f.Name())
+		if f.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %s", f.Name())
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: match[2]}
+			byVersion[version] = m
+		} else if m.name != match[2] {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", m.String(), f.Name())
+		}
+
+		if match[3] == "up" {
+			m.upPath = filepath.Join(dir, f.Name())
+		} else {
+			m.downPath = filepath.Join(dir, f.Name())
+		}
+	}
+
+	var migrations []*migration
+	for _, m := range byVersion {
+		if m.upPath == "" {
+			return nil, fmt.Errorf("migration %s has no up script", m.String())
+		}
+		migrations = append(migrations, m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	return migrations, nil
+}
+
+// createMigrationFiles creates empty up and down scripts numbered after the last migration of dir
+func createMigrationFiles(dir string, name string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	migrations, err := loadMigrations(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	version := int64(1)
+	if len(migrations) > 0 {
+		version = migrations[len(migrations)-1].version + 1
+	}
+
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%04d_%s.%s.cql", version, name, direction))
+		if err := ioutil.WriteFile(path, []byte{}, 0644); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+type appliedMigration struct {
+	version   int64
+	name      string
+	checksum  string
+	appliedAt time.Time
+	dirty     bool
+}
+
+// Migrator applies versioned migrations to a keyspace. Applied versions are recorded in its
+// schema_migrations table, and a lease in schema_migrations_lock, taken with a lightweight
+// transaction, keeps two deployers from running migrations at the same time.
+type Migrator struct {
+	session    *gocql.Session
+	keyspace   string
+	migrations []*migration
+	owner      string
+	lockTTL    time.Duration
+}
+
+func NewMigrator(s *gocql.Session, keyspace string, dir string, lockTTL time.Duration) (*Migrator, error) {
+	migrations, err := loadMigrations(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	m := &Migrator{
+		session:    s,
+		keyspace:   keyspace,
+		migrations: migrations,
+		owner:      hostname + ":" + strconv.Itoa(os.Getpid()),
+		lockTTL:    lockTTL,
+	}
+
+	for _, q := range []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (version bigint PRIMARY KEY, name text, checksum text,
+			applied_at timestamp, dirty boolean)`, qualifiedName(keyspace, "schema_migrations")),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id text PRIMARY KEY, owner text, acquired_at timestamp)",
+			qualifiedName(keyspace, "schema_migrations_lock")),
+	} {
+		if err := m.execute(q); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// execute runs a statement and waits for all the nodes to agree on the resulting schema
+func (m *Migrator) execute(q string, values ...interface{}) error {
+	if err := m.session.Query(q, values...).Exec(); err != nil {
+		return err
+	}
+
+	return m.session.AwaitSchemaAgreement(context.Background())
+}
+
+// lock takes the migration lease, it expires after lockTTL if the process dies while holding it
+func (m *Migrator) lock() error {
+	existing := make(map[string]interface{})
+	applied, err := m.session.Query(fmt.Sprintf(
+		"INSERT INTO %s (id, owner, acquired_at) VALUES ('lock', ?, ?) IF NOT EXISTS USING TTL ?",
+		qualifiedName(m.keyspace, "schema_migrations_lock")), m.owner, time.Now(), int(m.lockTTL.Seconds())).
+		MapScanCAS(existing)
+	if err != nil {
+		return err
+	}
+
+	if !applied {
+		return fmt.Errorf("migrations of %s are locked by %v since %v", m.keyspace, existing["owner"], existing["acquired_at"])
+	}
+
+	return nil
+}
+
+// renew extends the lease, for migrations running longer than lockTTL
+func (m *Migrator) renew() error {
+	existing := make(map[string]interface{})
+	applied, err := m.session.Query(fmt.Sprintf(
+		"UPDATE %s USING TTL ? SET owner = ?, acquired_at = ? WHERE id = 'lock' IF owner = ?",
+		qualifiedName(m.keyspace, "schema_migrations_lock")), int(m.lockTTL.Seconds()), m.owner, time.Now(), m.owner).
+		MapScanCAS(existing)
+	if err != nil {
+		return err
+	}
+
+	if !applied {
+		return fmt.Errorf("migration lock of %s has been lost", m.keyspace)
+	}
+
+	return nil
+}
+
+func (m *Migrator) unlock() {
+	existing := make(map[string]interface{})
+	_, err := m.session.Query(fmt.Sprintf("DELETE FROM %s WHERE id = 'lock' IF owner = ?",
+		qualifiedName(m.keyspace, "schema_migrations_lock")), m.owner).MapScanCAS(existing)
+	if err != nil {
+		log.Println("Unable to release the migration lock: " + err.Error())
+	}
+}
+
+func (m *Migrator) applied() (map[int64]*appliedMigration, error) {
+	iter := m.session.Query(fmt.Sprintf("SELECT version, name, checksum, applied_at, dirty FROM %s",
+		qualifiedName(m.keyspace, "schema_migrations"))).Iter()
+
+	applied := make(map[int64]*appliedMigration)
+	a := &appliedMigration{}
+	for iter.Scan(&a.version, &a.name, &a.checksum, &a.appliedAt, &a.dirty) {
+		applied[a.version] = a
+		a = &appliedMigration{}
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+// check refuses to go on when a migration failed halfway or an applied migration has been edited
+func (m *Migrator) check(applied map[int64]*appliedMigration) error {
+	for _, a := range applied {
+		if a.dirty {
+			return fmt.Errorf("migration %d_%s failed halfway, fix the schema then force a version", a.version, a.name)
+		}
+	}
+
+	for _, mig := range m.migrations {
+		a, ok := applied[mig.version]
+		if !ok {
+			continue
+		}
+
+		checksum, err := mig.checksum()
+		if err != nil {
+			return err
+		}
+
+		if checksum != a.checksum {
+			return fmt.Errorf("migration %s has been modified since it was applied", mig.String())
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) record(mig *migration, dirty bool) error {
+	checksum, err := mig.checksum()
+	if err != nil {
+		return err
+	}
+
+	return m.session.Query(fmt.Sprintf("INSERT INTO %s (version, name, checksum, applied_at, dirty) VALUES (?, ?, ?, ?, ?)",
+		qualifiedName(m.keyspace, "schema_migrations")), mig.version, mig.name, checksum, time.Now(), dirty).Exec()
+}
+
+func (m *Migrator) forget(version int64) error {
+	return m.session.Query(fmt.Sprintf("DELETE FROM %s WHERE version = ?",
+		qualifiedName(m.keyspace, "schema_migrations")), version).Exec()
+}
+
+// run executes the statements of a migration script, the migration is marked dirty until they all succeed
+func (m *Migrator) run(mig *migration, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	statements, err := splitStatements(string(data))
+	if err != nil {
+		return fmt.Errorf("invalid script %s: %s", path, err.Error())
+	}
+
+	if err := m.renew(); err != nil {
+		return err
+	}
+
+	if err := m.record(mig, true); err != nil {
+		return err
+	}
+
+	for _, q := range statements {
+		log.Println(q)
+		if err := m.execute(q); err != nil {
+			return fmt.Errorf("migration %s failed: %s", mig.String(), err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Up applies the pending migrations in version order, at most limit of them when it is positive
+func (m *Migrator) Up(limit int) error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.unlock()
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	if err := m.check(applied); err != nil {
+		return err
+	}
+
+	count := 0
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.version]; ok {
+			continue
+		}
+
+		if limit > 0 && count >= limit {
+			break
+		}
+
+		log.Println("Applying migration " + mig.String())
+		if err := m.run(mig, mig.upPath); err != nil {
+			return err
+		}
+
+		if err := m.record(mig, false); err != nil {
+			return err
+		}
+		count++
+	}
+
+	log.Println(strconv.Itoa(count) + " migrations applied")
+	return nil
+}
+
+// Down reverts the last limit applied migrations, the latest first
+func (m *Migrator) Down(limit int) error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.unlock()
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	if err := m.check(applied); err != nil {
+		return err
+	}
+
+	count := 0
+	for i := len(m.migrations) - 1; i >= 0 && count < limit; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.version]; !ok {
+			continue
+		}
+
+		if mig.downPath == "" {
+			return fmt.Errorf("migration %s has no down script", mig.String())
+		}
+
+		log.Println("Reverting migration " + mig.String())
+		if err := m.run(mig, mig.downPath); err != nil {
+			return err
+		}
+
+		if err := m.forget(mig.version); err != nil {
+			return err
+		}
+		count++
+	}
+
+	log.Println(strconv.Itoa(count) + " migrations reverted")
+	return nil
+}
+
+// Force records the migrations up to version as applied and the later ones as not applied, without
+// running any of them. It clears the state of a migration which failed halfway once fixed by hand.
+func (m *Migrator) Force(version int64) error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.unlock()
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for _, mig := range m.migrations {
+		if mig.version <= version {
+			if err := m.record(mig, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	for v := range applied {
+		if v > version {
+			if err := m.forget(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Status prints the state of every migration, known from the directory or the keyspace
+func (m *Migrator) Status() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	known := make(map[int64]bool)
+	for _, mig := range m.migrations {
+		known[mig.version] = true
+
+		state := "pending"
+		if a, ok := applied[mig.version]; ok {
+			checksum, err := mig.checksum()
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case a.dirty:
+				state = "dirty"
+			case a.checksum != checksum:
+				state = "modified since applied"
+			default:
+				state = "applied " + a.appliedAt.UTC().Format(time.RFC3339)
+			}
+		}
+
+		fmt.Println(mig.String() + ": " + state)
+	}
+
+	var missing []int64
+	for v := range applied {
+		if !known[v] {
+			missing = append(missing, v)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+
+	for _, v := range missing {
+		fmt.Println(strconv.FormatInt(v, 10) + "_" + applied[v].name + ": applied, no migration file")
+	}
+
+	return nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket refilled at rate tokens per second, holding at most one second of
+// tokens. A zero rate is unlimited.
+type rateLimiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+
+	// observed rate, measured over windows of about one second
+	count       float64
+	windowStart time.Time
+	observed    float64
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	now := time.Now()
+	return &rateLimiter{rate: rate, tokens: rate, last: now, windowStart: now}
+}
+
+func (l *rateLimiter) getRate() float64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.rate
+}
+
+func (l *rateLimiter) setRate(rate float64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.rate = rate
+	if l.tokens > rate {
+		l.tokens = rate
+	}
+}
+
+// scale multiplies the rate by factor, an unlimited rate is first set to the observed one
+func (l *rateLimiter) scale(factor float64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.rate == 0 {
+		if factor >= 1 || l.observed == 0 {
+			return
+		}
+		l.rate = l.observed
+	}
+
+	l.rate *= factor
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+}
+
+// wait takes n tokens, blocking until the bucket holds them. Tokens can be borrowed so a take larger
+// than the bucket waits for the time it takes to refill them.
+func (l *rateLimiter) wait(n float64) {
+	l.mutex.Lock()
+	now := time.Now()
+
+	l.count += n
+	if elapsed := now.Sub(l.windowStart).Seconds(); elapsed >= 1 {
+		l.observed = l.count / elapsed
+		l.count = 0
+		l.windowStart = now
+	}
+
+	if l.rate == 0 {
+		l.mutex.Unlock()
+		return
+	}
+
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= n
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mutex.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// RateLimits are maximum rates per second, zero is unlimited
+type RateLimits struct {
+	ReadRows   float64 `json:"read_rows"`
+	ReadBytes  float64 `json:"read_bytes"`
+	WriteRows  float64 `json:"write_rows"`
+	WriteBytes float64 `json:"write_bytes"`
+}
+
+func (r RateLimits) String() string {
+	format := func(v float64) string {
+		if v == 0 {
+			return "unlimited"
+		}
+		return fmt.Sprintf("%.0f/s", v)
+	}
+
+	return "read rows " + format(r.ReadRows) + ", read bytes " + format(r.ReadBytes) +
+		", write rows " + format(r.WriteRows) + ", write bytes " + format(r.WriteBytes)
+}
+
+// rateLimitsUpdate changes some of the limits, the ones left nil are kept
+type rateLimitsUpdate struct {
+	ReadRows   *float64 `json:"read_rows"`
+	ReadBytes  *float64 `json:"read_bytes"`
+	WriteRows  *float64 `json:"write_rows"`
+	WriteBytes *float64 `json:"write_bytes"`
+}
+
+// validate refuses negative limits, zero being unlimited a typo must not remove a limit
+func (u rateLimitsUpdate) validate() error {
+	for _, v := range []*float64{u.ReadRows, u.ReadBytes, u.WriteRows, u.WriteBytes} {
+		if v != nil && *v < 0 {
+			return fmt.Errorf("rate limits can't be negative")
+		}
+	}
+
+	return nil
+}
+
+// throttle limits the rows and bytes read and written by the whole transfer or by one table
+type throttle struct {
+	readRows   *rateLimiter
+	readBytes  *rateLimiter
+	writeRows  *rateLimiter
+	writeBytes *rateLimiter
+}
+
+func newThrottle(limits RateLimits) *throttle {
+	return &throttle{
+		readRows:   newRateLimiter(limits.ReadRows),
+		readBytes:  newRateLimiter(limits.ReadBytes),
+		writeRows:  newRateLimiter(limits.WriteRows),
+		writeBytes: newRateLimiter(limits.WriteBytes),
+	}
+}
+
+func (t *throttle) limiters() []*rateLimiter {
+	return []*rateLimiter{t.readRows, t.readBytes, t.writeRows, t.writeBytes}
+}
+
+func (t *throttle) limits() RateLimits {
+	return RateLimits{
+		ReadRows:   t.readRows.getRate(),
+		ReadBytes:  t.readBytes.getRate(),
+		WriteRows:  t.writeRows.getRate(),
+		WriteBytes: t.writeBytes.getRate(),
+	}
+}
+
+func (t *throttle) update(u rateLimitsUpdate) {
+	for i, v := range []*float64{u.ReadRows, u.ReadBytes, u.WriteRows, u.WriteBytes} {
+		if v != nil {
+			t.limiters()[i].setRate(*v)
+		}
+	}
+}
+
+func (t *throttle) read(rows int, bytes int) {
+	t.readRows.wait(float64(rows))
+	t.readBytes.wait(float64(bytes))
+}
+
+func (t *throttle) write(rows int, bytes int) {
+	t.writeRows.wait(float64(rows))
+	t.writeBytes.wait(float64(bytes))
+}
+
+// Throttler holds the rate limits of a transfer: the global ones, shared by all the tables, and the
+// ones of each table, which all start from the same table limits
+type Throttler struct {
+	mutex       sync.Mutex
+	global      *throttle
+	tableLimits RateLimits
+	tables      map[string]*throttle
+}
+
+func NewThrottler(global RateLimits, table RateLimits) *Throttler {
+	return &Throttler{
+		global:      newThrottle(global),
+		tableLimits: table,
+		tables:      make(map[string]*throttle),
+	}
+}
+
+func (tr *Throttler) table(name string) *throttle {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+
+	t, ok := tr.tables[name]
+	if !ok {
+		t = newThrottle(tr.tableLimits)
+		tr.tables[name] = t
+	}
+
+	return t
+}
+
+func (tr *Throttler) read(table string, rows int, bytes int) {
+	tr.global.read(rows, bytes)
+	tr.table(table).read(rows, bytes)
+}
+
+func (tr *Throttler) write(table string, rows int, bytes int) {
+	tr.global.write(rows, bytes)
+	tr.table(table).write(rows, bytes)
+}
+
+// Scale multiplies all the limits by factor, to slow down or speed up a running transfer
+func (tr *Throttler) Scale(factor float64) {
+	tr.mutex.Lock()
+	throttles := []*throttle{tr.global}
+	for _, t := range tr.tables {
+		throttles = append(throttles, t)
+	}
+	tr.mutex.Unlock()
+
+	for _, t := range throttles {
+		for _, l := range t.limiters() {
+			l.scale(factor)
+		}
+	}
+
+	tr.logLimits()
+}
+
+func (tr *Throttler) logLimits() {
+	tr.mutex.Lock()
+	var names []string
+	for name := range tr.tables {
+		names = append(names, name)
+	}
+	tr.mutex.Unlock()
+	sort.Strings(names)
+
+	log.Println("Rate limits: " + tr.global.limits().String())
+	for _, name := range names {
+		log.Println("Rate limits of " + name + ": " + tr.table(name).limits().String())
+	}
+}
+
+// throttlerState is the JSON document of the control endpoint
+type throttlerState struct {
+	Global RateLimits            `json:"global"`
+	Tables map[string]RateLimits `json:"tables"`
+}
+
+type throttlerUpdate struct {
+	Global rateLimitsUpdate            `json:"global"`
+	Tables map[string]rateLimitsUpdate `json:"tables"`
+}
+
+// ServeHTTP is the control endpoint: GET returns the current limits, POST changes some of them,
+// ex: {"global": {"write_rows": 1000}, "tables": {"events": {"read_bytes": 1048576}}}
+func (tr *Throttler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPut:
+		var u throttlerUpdate
+		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+			http.Error(w, "invalid limits: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		updates := []rateLimitsUpdate{u.Global}
+		for _, limits := range u.Tables {
+			updates = append(updates, limits)
+		}
+		for _, limits := range updates {
+			if err := limits.validate(); err != nil {
+				http.Error(w, "invalid limits: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		tr.global.update(u.Global)
+		for name, limits := range u.Tables {
+			tr.table(name).update(limits)
+		}
+		tr.logLimits()
+	default:
+		http.Error(w, "GET or POST expected", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state := throttlerState{Global: tr.global.limits(), Tables: make(map[string]RateLimits)}
+	tr.mutex.Lock()
+	for name, t := range tr.tables {
+		state.Tables[name] = t.limits()
+	}
+	tr.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		log.Println("Unable to write the rate limits: " + err.Error())
+	}
+}
+
+// Control serves the control endpoint on address and adjusts the limits on signals
+func (tr *Throttler) Control(address string) {
+	tr.handleSignals()
+
+	if address == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/limits", tr)
+
+	go func() {
+		log.Println("Rate limits control endpoint on " + address + "/limits")
+		if err := http.ListenAndServe(address, mux); err != nil {
+			log.Println("Control endpoint stopped: " + err.Error())
+		}
+	}()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/gocql/gocql"
+)
+
+// rawValue keeps the serialized bytes of a cell exactly as read from the source, so they
+// can be bound to the target statement untouched whatever the CQL type is.
+// A null cell has nil data while an empty value (empty text or blob, empty collection...)
+// has non nil zero length data, zeros and false are regular values.
+type rawValue struct {
+	info gocql.TypeInfo
+	data []byte
+}
+
+func (v *rawValue) UnmarshalCQL(info gocql.TypeInfo, data []byte) error {
+	v.info = info
+	if data == nil {
+		v.data = nil
+		return nil
+	}
+
+	// the driver reuses its frame buffer, keep our own copy
+	v.data = make([]byte, len(data))
+	copy(v.data, data)
+
+	return nil
+}
+
+func (v *rawValue) MarshalCQL(info gocql.TypeInfo) ([]byte, error) {
+	return v.data, nil
+}
+
+func (v *rawValue) isNull() bool {
+	return v.data == nil
+}
+
+// rawRow is a reusable scan destination holding one rawValue per selected column
+type rawRow struct {
+	columns []gocql.ColumnInfo
+	values  []*rawValue
+	tuples  map[int][]*rawValue
+	dest    []interface{}
+}
+
+func newRawRow(columns []gocql.ColumnInfo) *rawRow {
+	r := &rawRow{
+		columns: columns,
+		values:  make([]*rawValue, len(columns)),
+		tuples:  make(map[int][]*rawValue),
+	}
+
+	for i, col := range columns {
+		r.values[i] = &rawValue{info: col.TypeInfo}
+
+		// the driver expands top level tuples into one destination per element
+		if tuple, ok := col.TypeInfo.(gocql.TupleTypeInfo); ok {
+			for range tuple.Elems {
+				e := &rawValue{}
+				r.tuples[i] = append(r.tuples[i], e)
+				r.dest = append(r.dest, e)
+			}
+			continue
+		}
+
+		r.dest = append(r.dest, r.values[i])
+	}
+
+	return r
+}
+
+// scan reads the next row of iter, returns false when there is no more row
+func (r *rawRow) scan(iter *gocql.Iter) bool {
+	if !iter.Scan(r.dest...) {
+		return false
+	}
+
+	r.joinTuples()
+	return true
+}
+
+// joinTuples rebuilds the top level tuple values from their scanned elements
+func (r *rawRow) joinTuples() {
+	for i, elems := range r.tuples {
+		r.values[i].data = joinTupleElements(elems)
+	}
+}
+
+// size returns the number of bytes of the first columns of the row
+func (r *rawRow) size(columns int) int {
+	size := 0
+	for _, v := range r.values[:columns] {
+		size += len(v.data)
+	}
+
+	return size
+}
+
+// clone copies the values of the row, to keep them while the next row is scanned
+func (r *rawRow) clone() *rawRow {
+	c := &rawRow{
+		columns: r.columns,
+		values:  make([]*rawValue, len(r.values)),
+	}
+
+	// each scan allocates new data, the values can share it
+	for i, v := range r.values {
+		c.values[i] = &rawValue{info: v.info, data: v.data}
+	}
+
+	return c
+}
+
+// joinTupleElements serializes back the elements of a tuple the driver has split for us.
+// A null tuple is read as a tuple of null elements, those can't be told apart from a tuple
+// holding only nulls, both are returned as a null cell so a null tuple is not written as a value.
+// The driver also reads an empty last element as a null one, which is written back as such.
+func joinTupleElements(elems []*rawValue) []byte {
+	null := true
+	for _, e := range elems {
+		if e.data != nil {
+			null = false
+			break
+		}
+	}
+	if null {
+		return nil
+	}
+
+	var data []byte
+	for _, e := range elems {
+		size := make([]byte, 4)
+		if e.data == nil {
+			binary.BigEndian.PutUint32(size, 0xFFFFFFFF)
+			data = append(data, size...)
+			continue
+		}
+
+		binary.BigEndian.PutUint32(size, uint32(len(e.data)))
+		data = append(data, size...)
+		data = append(data, e.data...)
+	}
+
+	return data
+}
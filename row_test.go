@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func newTestRawRow(infos []gocql.TypeInfo) *rawRow {
+	var columns []gocql.ColumnInfo
+	for _, info := range infos {
+		columns = append(columns, gocql.ColumnInfo{TypeInfo: info})
+	}
+
+	return newRawRow(columns)
+}
+
+// scanTestRow reads serialized columns into a rawRow the way the driver scans a result row, top level
+// tuples being unmarshalled into one destination per element
+func scanTestRow(t *testing.T, r *rawRow, infos []gocql.TypeInfo, data [][]byte) *rawRow {
+	dest := r.dest
+	for i, info := range infos {
+		// the driver reuses its frame buffer once the row is scanned
+		var frame []byte
+		if data[i] != nil {
+			frame = make([]byte, len(data[i]))
+			copy(frame, data[i])
+		}
+
+		var err error
+		if tuple, ok := info.(gocql.TupleTypeInfo); ok {
+			err = gocql.Unmarshal(info, frame, dest[:len(tuple.Elems)])
+			dest = dest[len(tuple.Elems):]
+		} else {
+			err = gocql.Unmarshal(info, frame, dest[0])
+			dest = dest[1:]
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for j := range frame {
+			frame[j] = 0xAA
+		}
+	}
+	r.joinTuples()
+
+	return r
+}
+
+// checkRoundTrip checks a scanned value is bound back with the same bytes, null and empty included
+func checkRoundTrip(t *testing.T, info gocql.TypeInfo, v *rawValue, expected []byte) {
+	data, err := gocql.Marshal(info, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if (data == nil) != (expected == nil) || !bytes.Equal(data, expected) {
+		t.Errorf("expected %#v, got %#v", expected, data)
+	}
+}
+
+func TestRawValueRoundTrip(t *testing.T) {
+	tuple := gocql.TupleTypeInfo{
+		NativeType: nativeType(gocql.TypeTuple),
+		Elems:      []gocql.TypeInfo{nativeType(gocql.TypeInt), nativeType(gocql.TypeVarchar), nativeType(gocql.TypeBoolean)},
+	}
+	udt := gocql.UDTTypeInfo{
+		NativeType: nativeType(gocql.TypeUDT),
+		Name:       "address",
+		Elements:   []gocql.UDTField{{Name: "street", Type: nativeType(gocql.TypeVarchar)}},
+	}
+	vector := gocql.NewNativeType(testProto, gocql.TypeCustom, vectorClass+marshalPackage+"FloatType,2)")
+
+	type roundTripTest struct {
+		name string
+		info gocql.TypeInfo
+		data []byte
+	}
+
+	var tests []roundTripTest
+	for _, test := range getLiteralTests(t) {
+		tests = append(tests, roundTripTest{test.name, test.info, mustMarshal(t, test.info, test.value)})
+	}
+
+	tests = append(tests,
+		roundTripTest{"null int", nativeType(gocql.TypeInt), nil},
+		roundTripTest{"null text", nativeType(gocql.TypeVarchar), nil},
+		roundTripTest{"empty int", nativeType(gocql.TypeInt), []byte{}},
+		roundTripTest{"empty timestamp", nativeType(gocql.TypeTimestamp), []byte{}},
+		roundTripTest{"empty list", collectionType(gocql.TypeList, nil, nativeType(gocql.TypeInt)), []byte{}},
+		roundTripTest{"udt", udt, mustMarshal(t, udt, map[string]interface{}{"street": "main"})},
+		roundTripTest{"udt with null field", udt, []byte{0xFF, 0xFF, 0xFF, 0xFF}},
+		roundTripTest{"vector", vector, []byte{0x3F, 0xC0, 0, 0, 0xC0, 0, 0, 0}},
+		roundTripTest{"tuple", tuple, mustMarshal(t, tuple, []interface{}{1, "a", true})},
+		roundTripTest{"tuple with null element", tuple, []byte{0, 0, 0, 4, 0, 0, 0, 1, 0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 1, 1}},
+		roundTripTest{"tuple with empty element", tuple, []byte{0, 0, 0, 4, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 1}},
+		roundTripTest{"tuple with null first element", tuple, []byte{0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 1, 'a', 0, 0, 0, 1, 0}},
+		roundTripTest{"null tuple", tuple, nil},
+	)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			infos := []gocql.TypeInfo{test.info}
+			r := scanTestRow(t, newTestRawRow(infos), infos, [][]byte{test.data})
+			checkRoundTrip(t, test.info, r.values[0], test.data)
+		})
+	}
+}
+
+func TestRawValueRoundTripTupleLimits(t *testing.T) {
+	tuple := gocql.TupleTypeInfo{
+		NativeType: nativeType(gocql.TypeTuple),
+		Elems:      []gocql.TypeInfo{nativeType(gocql.TypeInt), nativeType(gocql.TypeVarchar)},
+	}
+
+	infos := []gocql.TypeInfo{tuple}
+
+	// a tuple of null elements can't be told apart from a null tuple, it is left null
+	r := scanTestRow(t, newTestRawRow(infos), infos, [][]byte{{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}})
+	if !r.values[0].isNull() {
+		t.Errorf("expected a null tuple, got %#v", r.values[0].data)
+	}
+
+	// the driver reads an empty last element as a null one
+	r = scanTestRow(t, newTestRawRow(infos), infos, [][]byte{{0, 0, 0, 4, 0, 0, 0, 1, 0, 0, 0, 0}})
+	checkRoundTrip(t, tuple, r.values[0], []byte{0, 0, 0, 4, 0, 0, 0, 1, 0xFF, 0xFF, 0xFF, 0xFF})
+}
+
+func TestRawRowRoundTrip(t *testing.T) {
+	tuple := gocql.TupleTypeInfo{
+		NativeType: nativeType(gocql.TypeTuple),
+		Elems:      []gocql.TypeInfo{nativeType(gocql.TypeBigInt), nativeType(gocql.TypeVarchar)},
+	}
+	infos := []gocql.TypeInfo{nativeType(gocql.TypeInt), tuple, nativeType(gocql.TypeVarchar), tuple}
+	data := [][]byte{
+		mustMarshal(t, infos[0], 7),
+		mustMarshal(t, tuple, []interface{}{int64(1), "x"}),
+		[]byte{},
+		nil,
+	}
+
+	// the elements of the tuples are scanned in their own destinations, the columns after them must
+	// keep their values
+	r := scanTestRow(t, newTestRawRow(infos), infos, data)
+	for i, info := range infos {
+		checkRoundTrip(t, info, r.values[i], data[i])
+	}
+
+	// the row is reused for the next one, a clone keeps its values
+	clone := r.clone()
+	scanTestRow(t, r, infos, [][]byte{nil, nil, nil, nil})
+	for i, info := range infos {
+		checkRoundTrip(t, info, clone.values[i], data[i])
+		checkRoundTrip(t, info, r.values[i], nil)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gocql/gocql"
+)
+
+var unquotedIdentifier = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// CQL reserved keywords, they can't be used as identifiers unless quoted
+var reservedKeywords = map[string]bool{
+	"add": true, "allow": true, "alter": true, "and": true, "apply": true, "asc": true, "authorize": true,
+	"batch": true, "begin": true, "by": true, "columnfamily": true, "create": true, "default": true,
+	"delete": true, "desc": true, "describe": true, "drop": true, "entries": true, "execute": true,
+	"from": true, "full": true, "grant": true, "if": true, "in": true, "index": true, "infinity": true,
+	"insert": true, "into": true, "is": true, "keyspace": true, "limit": true, "materialized": true,
+	"mbean": true, "mbeans": true, "modify": true, "nan": true, "norecursive": true, "not": true,
+	"null": true, "of": true, "on": true, "or": true, "order": true, "primary": true, "rename": true,
+	"replace": true, "revoke": true, "schema": true, "select": true, "set": true, "table": true,
+	"to": true, "token": true, "truncate": true, "unlogged": true, "unset": true, "update": true,
+	"use": true, "using": true, "view": true, "where": true, "with": true,
+}
+
+// cassandra 2.x marshal classes of the native types
+var marshalTypes = map[string]string{
+	"AsciiType":         "ascii",
+	"BooleanType":       "boolean",
+	"ByteType":          "tinyint",
+	"BytesType":         "blob",
+	"CounterColumnType": "counter",
+	"DateType":          "timestamp",
+	"DecimalType":       "decimal",
+	"DoubleType":        "double",
+	"DurationType":      "duration",
+	"FloatType":         "float",
+	"InetAddressType":   "inet",
+	"Int32Type":         "int",
+	"IntegerType":       "varint",
+	"LongType":          "bigint",
+	"ShortType":         "smallint",
+	"SimpleDateType":    "date",
+	"TimeType":          "time",
+	"TimeUUIDType":      "timeuuid",
+	"TimestampType":     "timestamp",
+	"UTF8Type":          "text",
+	"UUIDType":          "uuid",
+}
+
+const marshalPackage = "org.apache.cassandra.db.marshal."
+
+// quoteIdentifier quotes a keyspace, table, column or type name when it is case sensitive,
+// contains special characters or is a reserved keyword
+func quoteIdentifier(name string) string {
+	if unquotedIdentifier.MatchString(name) && !reservedKeywords[name] {
+		return name
+	}
+
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func quoteIdentifiers(names []string) []string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteIdentifier(name)
+	}
+
+	return quoted
+}
+
+func qualifiedName(keyspace string, name string) string {
+	return quoteIdentifier(keyspace) + "." + quoteIdentifier(name)
+}
+
+// splitTypeParameters splits the comma separated parameters of a type, ignoring nested ones
+func splitTypeParameters(params string) []string {
+	var parts []string
+	depth := 0
+	start := 0
+	for i, char := range params {
+		switch char {
+		case '(', '<':
+			depth++
+		case ')', '>':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(params[start:i]))
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, strings.TrimSpace(params[start:]))
+}
+
+// getCQLType returns the CQL type of a column validator. Cassandra 3.0+ validators are already
+// CQL types, cassandra 2.x ones are marshal class names which are converted.
+func (c *Cassandra) getCQLType(validator string) string {
+	if !strings.HasPrefix(validator, marshalPackage) {
+		return validator
+	}
+
+	return c.getMarshalCQLType(validator, false)
+}
+
+func (c *Cassandra) getMarshalCQLType(class string, frozen bool) string {
+	class = strings.TrimPrefix(strings.TrimSpace(class), marshalPackage)
+	name, params := class, ""
+	if i := strings.Index(class, "("); i > 0 && strings.HasSuffix(class, ")") {
+		name, params = class[:i], class[i+1:len(class)-1]
+	}
+
+	if t, ok := marshalTypes[name]; ok {
+		return t
+	}
+
+	if params == "" {
+		return "'" + marshalPackage + class + "'"
+	}
+
+	var types []string
+	for _, p := range splitTypeParameters(params) {
+		if name != "UserType" {
+			types = append(types, c.getMarshalCQLType(p, frozen || name == "FrozenType"))
+		} else {
+			types = append(types, p)
+		}
+	}
+
+	switch name {
+	case "ReversedType":
+		return types[0]
+	case "FrozenType":
+		return "frozen<" + types[0] + ">"
+	case "ListType":
+		return "list<" + types[0] + ">"
+	case "SetType":
+		return "set<" + types[0] + ">"
+	case "MapType":
+		return "map<" + strings.Join(types, ", ") + ">"
+	case "TupleType":
+		return "tuple<" + strings.Join(types, ", ") + ">"
+	case "UserType":
+		// UserType(keyspace,hex name,field:type...), user types are always frozen before cassandra 3.6
+		typeName, err := hex.DecodeString(types[1])
+		if err != nil {
+			return "'" + marshalPackage + class + "'"
+		}
+
+		if frozen {
+			return quoteIdentifier(string(typeName))
+		}
+		return "frozen<" + quoteIdentifier(string(typeName)) + ">"
+	default:
+		return "'" + marshalPackage + class + "'"
+	}
+}
+
+// getCreateTableQuery builds the DDL of a table, partition key and clustering columns come first in
+// their declaration order, then static and regular columns sorted by name as cassandra does
+func (c *Cassandra) getCreateTableQuery(keyspace string, table *gocql.TableMetadata, options map[string]string) string {
+	var columns []string
+	var pkColumns []string
+	for _, column := range table.PartitionKey {
+		columns = append(columns, quoteIdentifier(column.Name)+" "+c.getCQLType(column.Validator))
+		pkColumns = append(pkColumns, quoteIdentifier(column.Name))
+	}
+
+	var clusteringColumns []string
+	var clusteringOrder []string
+	for _, column := range table.ClusteringColumns {
+		columns = append(columns, quoteIdentifier(column.Name)+" "+c.getCQLType(column.Validator))
+		clusteringColumns = append(clusteringColumns, quoteIdentifier(column.Name))
+
+		order := "ASC"
+		if column.Order == gocql.DESC {
+			order = "DESC"
+		}
+		clusteringOrder = append(clusteringOrder, quoteIdentifier(column.Name)+" "+order)
+	}
+
+	var names []string
+	for name, column := range table.Columns {
+		if column.Kind != gocql.ColumnPartitionKey && column.Kind != gocql.ColumnClusteringKey {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		column := table.Columns[name]
+		definition := quoteIdentifier(name) + " " + c.getCQLType(column.Validator)
+		if column.Kind == gocql.ColumnStatic {
+			definition += " STATIC"
+		}
+		columns = append(columns, definition)
+	}
+
+	primaryKey := "(" + strings.Join(pkColumns, ", ") + ")"
+	if len(clusteringColumns) > 0 {
+		primaryKey += ", " + strings.Join(clusteringColumns, ", ")
+	}
+
+	var with []string
+	if len(clusteringOrder) > 0 {
+		with = append(with, "CLUSTERING ORDER BY ("+strings.Join(clusteringOrder, ", ")+")")
+	}
+	with = append(with, c.getTableOptionsString(options)...)
+
+	q := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s, PRIMARY KEY (%s))",
+		qualifiedName(keyspace, table.Name), strings.Join(columns, ", "), primaryKey)
+	if len(with) > 0 {
+		q += " WITH " + strings.Join(with, " AND ")
+	}
+
+	return q + ";"
+}
+
+// schema columns of system_schema.tables which are not table options
+var ignoredTableOptions = map[string]bool{
+	"keyspace_name": true,
+	"table_name":    true,
+	"id":            true,
+	"flags":         true,
+	"extensions":    true,
+}
+
+// getOptionValueString renders a table option value as a CQL literal
+func (c *Cassandra) getOptionValueString(v interface{}) string {
+	switch v := v.(type) {
+	case map[string]string:
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var values []string
+		for _, k := range keys {
+			values = append(values, c.getStringOrNumber(k)+": "+c.getStringOrNumber(v[k]))
+		}
+
+		return "{" + strings.Join(values, ", ") + "}"
+	default:
+		return c.getStringOrNumber(v)
+	}
+}
+
+// getTableOptions reads the options of a table (compaction, compression, caching...) rendered as
+// CQL literals. They are only available from the system_schema keyspace of cassandra 3.0+.
+func (c *Cassandra) getTableOptions(s *gocql.Session, keyspace string, table string) map[string]string {
+	row := make(map[string]interface{})
+	err := s.Query("SELECT * FROM system_schema.tables WHERE keyspace_name = ? AND table_name = ?",
+		keyspace, table).MapScan(row)
+	if err != nil {
+		log.Println("Unable to read " + keyspace + "." + table + " options, defaults will be used: " + err.Error())
+		return map[string]string{}
+	}
+
+	return c.getSchemaOptions(row, ignoredTableOptions)
+}
+
+// getSchemaOptions renders the options of a system_schema row, leaving out the ignored columns
+func (c *Cassandra) getSchemaOptions(row map[string]interface{}, ignored map[string]bool) map[string]string {
+	options := make(map[string]string)
+	for option, value := range row {
+		if ignored[option] || value == nil {
+			continue
+		}
+		options[option] = c.getOptionValueString(value)
+	}
+
+	return options
+}
+
+// getKnownOptions returns the options the target cluster knows for its tables or views, the columns of
+// system_schema.tables or system_schema.views, nil when they can't be read as on cassandra 2.x
+func (c *Cassandra) getKnownOptions(s *gocql.Session, schemaTable string) map[string]bool {
+	iter := s.Query("SELECT column_name FROM system_schema.columns WHERE keyspace_name = 'system_schema' AND table_name = ?",
+		schemaTable).Iter()
+
+	known := make(map[string]bool)
+	var column string
+	for iter.Scan(&column) {
+		known[column] = true
+	}
+
+	if err := iter.Close(); err != nil || len(known) == 0 {
+		log.Println("Unable to read the " + schemaTable + " options of the target cluster, all the source ones will be kept")
+		return nil
+	}
+
+	return known
+}
+
+// filterOptions leaves out the options unknown to the target cluster, as the ones removed by a newer
+// cassandra version, all of them are kept when known is nil
+func (c *Cassandra) filterOptions(name string, options map[string]string, known map[string]bool) map[string]string {
+	if known == nil {
+		return options
+	}
+
+	filtered := make(map[string]string, len(options))
+	for option, value := range options {
+		if !known[option] {
+			log.Println(name + ": option " + option + " unknown to the target cluster, dropped")
+			continue
+		}
+		filtered[option] = value
+	}
+
+	return filtered
+}
+
+// mergeTableOptions applies option overrides given as CQL literals, an empty override removes the option
+func (c *Cassandra) mergeTableOptions(options map[string]string, overrides map[string]string) map[string]string {
+	merged := make(map[string]string, len(options))
+	for option, value := range options {
+		merged[option] = value
+	}
+
+	for option, value := range overrides {
+		if value == "" {
+			delete(merged, option)
+			continue
+		}
+		merged[option] = value
+	}
+
+	return merged
+}
+
+func (c *Cassandra) getTableOptionsString(options map[string]string) []string {
+	var names []string
+	for option := range options {
+		names = append(names, option)
+	}
+	sort.Strings(names)
+
+	var with []string
+	for _, option := range names {
+		with = append(with, fmt.Sprintf("%s = %s", option, options[option]))
+	}
+
+	return with
+}
+
+// getTableNames returns the sorted names of the keyspace tables, views excepted, only table when it isn't empty
+func (c *Cassandra) getTableNames(k *gocql.KeyspaceMetadata, table string) []string {
+	var names []string
+	for name := range k.Tables {
+		if (table == "" || name == table) && !c.isView(k, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// getCreateTablesQueries returns the DDL of the source keyspace tables sorted by name, views excepted.
+// Only the options known to the target are kept, all of them when known is nil.
+func (c *Cassandra) getCreateTablesQueries(s *gocql.Session, k *gocql.KeyspaceMetadata, toKeyspace string,
+	options TransferOptions, known map[string]bool) []string {
+
+	var queries []string
+	for _, name := range c.getTableNames(k, options.Table) {
+		tableOptions := c.filterOptions(toKeyspace+"."+name,
+			c.mergeTableOptions(c.getTableOptions(s, k.Name, name), options.TableOptions), known)
+		queries = append(queries, c.getCreateTableQuery(toKeyspace, k.Tables[name], tableOptions))
+	}
+
+	return queries
+}
+
+// getKeyspaceQueries returns the whole DDL of the source keyspace in the order it must be run in the
+// target one: keyspace, user types, functions and aggregates, tables, then indexes and views
+func (c *Cassandra) getKeyspaceQueries(s *gocql.Session, k *gocql.KeyspaceMetadata, toKeyspace string,
+	options TransferOptions) []string {
+
+	queries := []string{c.getKeyspaceQuery(k, toKeyspace, options)}
+	queries = append(queries, c.getUserDefinedQueries(s, k.Name, toKeyspace)...)
+	queries = append(queries, c.getCreateTablesQueries(s, k, toKeyspace, options, nil)...)
+	queries = append(queries, c.getIndexesAndViewsQueries(s, k.Name, toKeyspace, options.Table, nil)...)
+
+	return queries
+}
+
+func (c *Cassandra) executeQueries(s *gocql.Session, queries []string) {
+	for _, q := range queries {
+		log.Println(q)
+		if err := s.Query(q).Exec(); err != nil {
+			panic(err)
+		}
+	}
+}
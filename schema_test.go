@@ -0,0 +1,159 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func TestGetMarshalCQLType(t *testing.T) {
+	c := Cassandra{}
+	tests := []struct {
+		name      string
+		validator string
+		expected  string
+	}{
+		{"native", "org.apache.cassandra.db.marshal.UTF8Type", "text"},
+		{"timestamp", "org.apache.cassandra.db.marshal.TimestampType", "timestamp"},
+		{"varint", "org.apache.cassandra.db.marshal.IntegerType", "varint"},
+		{"reversed", "org.apache.cassandra.db.marshal.ReversedType(org.apache.cassandra.db.marshal.TimeUUIDType)", "timeuuid"},
+		{"list", "org.apache.cassandra.db.marshal.ListType(org.apache.cassandra.db.marshal.Int32Type)", "list<int>"},
+		{"set", "org.apache.cassandra.db.marshal.SetType(org.apache.cassandra.db.marshal.UTF8Type)", "set<text>"},
+		{"map", "org.apache.cassandra.db.marshal.MapType(org.apache.cassandra.db.marshal.UTF8Type,org.apache.cassandra.db.marshal.LongType)",
+			"map<text, bigint>"},
+		{"frozen list", "org.apache.cassandra.db.marshal.FrozenType(org.apache.cassandra.db.marshal.ListType(org.apache.cassandra.db.marshal.Int32Type))",
+			"frozen<list<int>>"},
+		{"nested collections", "org.apache.cassandra.db.marshal.MapType(org.apache.cassandra.db.marshal.UTF8Type," +
+			"org.apache.cassandra.db.marshal.FrozenType(org.apache.cassandra.db.marshal.SetType(org.apache.cassandra.db.marshal.UUIDType)))",
+			"map<text, frozen<set<uuid>>>"},
+		{"tuple", "org.apache.cassandra.db.marshal.TupleType(org.apache.cassandra.db.marshal.Int32Type,org.apache.cassandra.db.marshal.UTF8Type)",
+			"tuple<int, text>"},
+		{"user type", "org.apache.cassandra.db.marshal.UserType(ks,61646472657373,737472656574:org.apache.cassandra.db.marshal.UTF8Type)",
+			"frozen<address>"},
+		{"case sensitive user type", "org.apache.cassandra.db.marshal.UserType(ks,466f6f,78:org.apache.cassandra.db.marshal.Int32Type)",
+			`frozen<"Foo">`},
+		{"frozen user type in list", "org.apache.cassandra.db.marshal.ListType(org.apache.cassandra.db.marshal.FrozenType(" +
+			"org.apache.cassandra.db.marshal.UserType(ks,61646472657373,737472656574:org.apache.cassandra.db.marshal.UTF8Type)))",
+			"list<frozen<address>>"},
+		{"reversed tuple", "org.apache.cassandra.db.marshal.ReversedType(org.apache.cassandra.db.marshal.TupleType(" +
+			"org.apache.cassandra.db.marshal.LongType,org.apache.cassandra.db.marshal.DoubleType))",
+			"tuple<bigint, double>"},
+		{"custom", "org.apache.cassandra.db.marshal.LexicalUUIDType", "'org.apache.cassandra.db.marshal.LexicalUUIDType'"},
+		{"custom with parameters", "org.apache.cassandra.db.marshal.DynamicCompositeType(a=>org.apache.cassandra.db.marshal.BytesType)",
+			"'org.apache.cassandra.db.marshal.DynamicCompositeType(a=>org.apache.cassandra.db.marshal.BytesType)'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := c.getCQLType(test.validator); actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func newTestTable(name string, columns ...*gocql.ColumnMetadata) *gocql.TableMetadata {
+	table := &gocql.TableMetadata{Name: name, Columns: make(map[string]*gocql.ColumnMetadata)}
+	for _, column := range columns {
+		table.Columns[column.Name] = column
+		switch column.Kind {
+		case gocql.ColumnPartitionKey:
+			table.PartitionKey = append(table.PartitionKey, column)
+		case gocql.ColumnClusteringKey:
+			table.ClusteringColumns = append(table.ClusteringColumns, column)
+		}
+	}
+
+	return table
+}
+
+func TestGetCreateTableQuery(t *testing.T) {
+	c := Cassandra{}
+	tests := []struct {
+		name     string
+		table    *gocql.TableMetadata
+		options  map[string]string
+		expected string
+	}{
+		{
+			name: "simple",
+			table: newTestTable("users",
+				&gocql.ColumnMetadata{Name: "id", Kind: gocql.ColumnPartitionKey, Validator: "uuid"},
+				&gocql.ColumnMetadata{Name: "name", Kind: gocql.ColumnRegular, Validator: "text"}),
+			expected: "CREATE TABLE IF NOT EXISTS ks.users (id uuid, name text, PRIMARY KEY ((id)));",
+		},
+		{
+			name: "composite partition key and mixed clustering order",
+			table: newTestTable("events",
+				&gocql.ColumnMetadata{Name: "tenant", Kind: gocql.ColumnPartitionKey, Validator: "text"},
+				&gocql.ColumnMetadata{Name: "day", Kind: gocql.ColumnPartitionKey, Validator: "date"},
+				&gocql.ColumnMetadata{Name: "at", Kind: gocql.ColumnClusteringKey, Validator: "timestamp", Order: gocql.DESC},
+				&gocql.ColumnMetadata{Name: "seq", Kind: gocql.ColumnClusteringKey, Validator: "int", Order: gocql.ASC},
+				&gocql.ColumnMetadata{Name: "id", Kind: gocql.ColumnClusteringKey, Validator: "timeuuid", Order: gocql.DESC},
+				&gocql.ColumnMetadata{Name: "payload", Kind: gocql.ColumnRegular, Validator: "blob"}),
+			expected: "CREATE TABLE IF NOT EXISTS ks.events (tenant text, day date, at timestamp, seq int, id timeuuid, " +
+				"payload blob, PRIMARY KEY ((tenant, day), at, seq, id)) WITH CLUSTERING ORDER BY (at DESC, seq ASC, id DESC);",
+		},
+		{
+			name: "static columns sorted with regular ones",
+			table: newTestTable("carts",
+				&gocql.ColumnMetadata{Name: "user", Kind: gocql.ColumnPartitionKey, Validator: "uuid"},
+				&gocql.ColumnMetadata{Name: "item", Kind: gocql.ColumnClusteringKey, Validator: "text"},
+				&gocql.ColumnMetadata{Name: "total", Kind: gocql.ColumnStatic, Validator: "decimal"},
+				&gocql.ColumnMetadata{Name: "count", Kind: gocql.ColumnRegular, Validator: "int"}),
+			expected: "CREATE TABLE IF NOT EXISTS ks.carts (user uuid, item text, count int, total decimal STATIC, " +
+				"PRIMARY KEY ((user), item)) WITH CLUSTERING ORDER BY (item ASC);",
+		},
+		{
+			name: "frozen and nested collections",
+			table: newTestTable("docs",
+				&gocql.ColumnMetadata{Name: "id", Kind: gocql.ColumnPartitionKey, Validator: "frozen<list<int>>"},
+				&gocql.ColumnMetadata{Name: "tags", Kind: gocql.ColumnRegular, Validator: "map<text, frozen<set<uuid>>>"},
+				&gocql.ColumnMetadata{Name: "points", Kind: gocql.ColumnRegular, Validator: "list<frozen<tuple<int, text>>>"}),
+			expected: "CREATE TABLE IF NOT EXISTS ks.docs (id frozen<list<int>>, points list<frozen<tuple<int, text>>>, " +
+				"tags map<text, frozen<set<uuid>>>, PRIMARY KEY ((id)));",
+		},
+		{
+			name: "cassandra 2.x validators",
+			table: newTestTable("legacy",
+				&gocql.ColumnMetadata{Name: "id", Kind: gocql.ColumnPartitionKey, Validator: "org.apache.cassandra.db.marshal.UUIDType"},
+				&gocql.ColumnMetadata{Name: "at", Kind: gocql.ColumnClusteringKey, Order: gocql.DESC,
+					Validator: "org.apache.cassandra.db.marshal.ReversedType(org.apache.cassandra.db.marshal.TimestampType)"},
+				&gocql.ColumnMetadata{Name: "home", Kind: gocql.ColumnRegular,
+					Validator: "org.apache.cassandra.db.marshal.UserType(ks,61646472657373,737472656574:org.apache.cassandra.db.marshal.UTF8Type)"},
+				&gocql.ColumnMetadata{Name: "emails", Kind: gocql.ColumnRegular,
+					Validator: "org.apache.cassandra.db.marshal.SetType(org.apache.cassandra.db.marshal.UTF8Type)"}),
+			expected: "CREATE TABLE IF NOT EXISTS ks.legacy (id uuid, at timestamp, emails set<text>, home frozen<address>, " +
+				"PRIMARY KEY ((id), at)) WITH CLUSTERING ORDER BY (at DESC);",
+		},
+		{
+			name: "case sensitive and reserved identifiers",
+			table: newTestTable("Select",
+				&gocql.ColumnMetadata{Name: "Foo", Kind: gocql.ColumnPartitionKey, Validator: "text"},
+				&gocql.ColumnMetadata{Name: "select", Kind: gocql.ColumnClusteringKey, Validator: "int", Order: gocql.DESC},
+				&gocql.ColumnMetadata{Name: `a"b`, Kind: gocql.ColumnRegular, Validator: "text"},
+				&gocql.ColumnMetadata{Name: "value", Kind: gocql.ColumnRegular, Validator: `frozen<"Address">`}),
+			expected: `CREATE TABLE IF NOT EXISTS ks."Select" ("Foo" text, "select" int, "a""b" text, value frozen<"Address">, ` +
+				`PRIMARY KEY (("Foo"), "select")) WITH CLUSTERING ORDER BY ("select" DESC);`,
+		},
+		{
+			name: "options",
+			table: newTestTable("logs",
+				&gocql.ColumnMetadata{Name: "id", Kind: gocql.ColumnPartitionKey, Validator: "uuid"}),
+			options: map[string]string{
+				"gc_grace_seconds": "3600",
+				"compaction":       "{'class': 'org.apache.cassandra.db.compaction.TimeWindowCompactionStrategy'}",
+			},
+			expected: "CREATE TABLE IF NOT EXISTS ks.logs (id uuid, PRIMARY KEY ((id))) WITH " +
+				"compaction = {'class': 'org.apache.cassandra.db.compaction.TimeWindowCompactionStrategy'} AND gc_grace_seconds = 3600;",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := c.getCreateTableQuery("ks", test.table, test.options); actual != test.expected {
+				t.Errorf("expected\n%s\ngot\n%s", test.expected, actual)
+			}
+		})
+	}
+}
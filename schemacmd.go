@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var SchemaOutput = ""
+var SchemaJSON = false
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema [COMMANDS]",
+	Short: "export, compare and apply keyspace schemas",
+}
+
+var schemaExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "write the schema of a keyspace as an ordered CQL script",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if From.Host == "" {
+			return fmt.Errorf("FROM host is mandatory")
+		}
+
+		if FromKeyspace == "" {
+			return fmt.Errorf("FROM keyspace is mandatory")
+		}
+
+		if err := From.resolve("from"); err != nil {
+			return err
+		}
+
+		for _, values := range [][]string{Replication, DCMap, TableOptions} {
+			if _, err := parseKeyValues(values); err != nil {
+				return err
+			}
+		}
+
+		if ToKeyspace == "" {
+			ToKeyspace = FromKeyspace
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		replication, _ := parseKeyValues(Replication)
+		dcMap, _ := parseKeyValues(DCMap)
+		tableOptions, _ := parseKeyValues(TableOptions)
+
+		var durableWrites *bool
+		if cmd.Flags().Changed("durable-writes") {
+			durableWrites = &DurableWrites
+		}
+
+		c := Cassandra{}
+		s := c.getCassandraSession(From)
+		defer s.Close()
+
+		k, err := s.KeyspaceMetadata(FromKeyspace)
+		if err != nil {
+			panic(err)
+		}
+
+		queries := c.getKeyspaceQueries(s, k, ToKeyspace, TransferOptions{
+			Table:         Table,
+			Replication:   replication,
+			DCMap:         dcMap,
+			DurableWrites: durableWrites,
+			TableOptions:  tableOptions,
+		})
+
+		script := "-- schema of keyspace " + ToKeyspace + ", exported from " + FromKeyspace + "\n\n" +
+			strings.Join(queries, "\n\n") + "\n"
+
+		if SchemaOutput == "" || SchemaOutput == "-" {
+			fmt.Print(script)
+			return
+		}
+
+		if err := ioutil.WriteFile(SchemaOutput, []byte(script), 0644); err != nil {
+			panic(err)
+		}
+		log.Printf("%d statements written to %s", len(queries), SchemaOutput)
+	},
+}
+
+var schemaApplyCmd = &cobra.Command{
+	Use:   "apply FILE",
+	Short: "run a CQL script on a cluster, statement by statement",
+	Args:  cobra.ExactArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if To.Host == "" {
+			return fmt.Errorf("TO host is mandatory")
+		}
+
+		return To.resolve("to")
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var data []byte
+		var err error
+		if args[0] == "-" {
+			data, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			data, err = ioutil.ReadFile(args[0])
+		}
+		if err != nil {
+			panic(err)
+		}
+
+		statements, err := splitStatements(string(data))
+		if err != nil {
+			panic(fmt.Errorf("invalid script %s: %s", args[0], err.Error()))
+		}
+
+		c := Cassandra{}
+		s := c.getCassandraSession(To)
+		defer s.Close()
+
+		for _, q := range statements {
+			c.executeQueries(s, []string{q})
+
+			// the next statements may depend on this one on any node
+			if err := s.AwaitSchemaAgreement(context.Background()); err != nil {
+				panic(err)
+			}
+		}
+
+		log.Printf("%d statements applied", len(statements))
+	},
+}
+
+var schemaDiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "compare the tables of a source keyspace with the target ones, exits with 1 on incompatible differences",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if From.Host == "" {
+			return fmt.Errorf("FROM host is mandatory")
+		}
+
+		if To.Host == "" {
+			return fmt.Errorf("TO host is mandatory")
+		}
+
+		if FromKeyspace == "" {
+			return fmt.Errorf("FROM keyspace is mandatory")
+		}
+
+		if err := From.resolve("from"); err != nil {
+			return err
+		}
+
+		if err := To.resolve("to"); err != nil {
+			return err
+		}
+
+		if ToKeyspace == "" {
+			ToKeyspace = FromKeyspace
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		c := Cassandra{}
+		s1 := c.getCassandraSession(From)
+		s2 := c.getCassandraSession(To)
+
+		differences := c.getSchemaDifferences(s1, s2, FromKeyspace, ToKeyspace, Table)
+		s1.Close()
+		s2.Close()
+
+		if SchemaJSON {
+			// always an array, even without differences
+			if differences == nil {
+				differences = []*schemaDifference{}
+			}
+
+			data, err := json.MarshalIndent(struct {
+				FromKeyspace string              `json:"from_keyspace"`
+				ToKeyspace   string              `json:"to_keyspace"`
+				Incompatible bool                `json:"incompatible"`
+				Differences  []*schemaDifference `json:"differences"`
+			}{FromKeyspace, ToKeyspace, isIncompatible(differences), differences}, "", "  ")
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println(string(data))
+		} else {
+			for _, d := range differences {
+				fmt.Println(d.String())
+			}
+			if len(differences) == 0 {
+				fmt.Println("no difference")
+			}
+		}
+
+		if isIncompatible(differences) {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	schemaExportCmd.Flags().StringVarP(&From.Host, "from-host", "f", From.Host, "cassandra1:9042,cassandra2:9042")
+	schemaExportCmd.Flags().StringVarP(&FromKeyspace, "from-keyspace", "i", FromKeyspace, "keyspace_name")
+	schemaExportCmd.Flags().StringVarP(&ToKeyspace, "to-keyspace", "o", ToKeyspace, "keyspace name used in the script, the exported one by default")
+	schemaExportCmd.Flags().StringVarP(&Table, "table", "a", Table, "only export this table, its indexes and views")
+	schemaExportCmd.Flags().StringVar(&SchemaOutput, "output", SchemaOutput, "file to write the script to, stdout by default")
+	schemaExportCmd.Flags().StringSliceVar(&Replication, "replication", Replication, "override the keyspace replication, ex: class=NetworkTopologyStrategy,dc1=3")
+	schemaExportCmd.Flags().StringSliceVar(&DCMap, "dc-map", DCMap, "rename datacenters in the replication, ex: source_dc=target_dc")
+	schemaExportCmd.Flags().BoolVar(&DurableWrites, "durable-writes", DurableWrites, "override the keyspace durable writes")
+	schemaExportCmd.Flags().StringArrayVar(&TableOptions, "table-option", TableOptions, "override a table option with a CQL value, empty to drop it, ex: gc_grace_seconds=3600")
+	addClusterFlags(schemaExportCmd.Flags(), "from", &From)
+
+	schemaApplyCmd.Flags().StringVarP(&To.Host, "to-host", "t", To.Host, "cassandra3:9042,cassandra4:9042")
+	addClusterFlags(schemaApplyCmd.Flags(), "to", &To)
+
+	schemaDiffCmd.Flags().StringVarP(&From.Host, "from-host", "f", From.Host, "cassandra1:9042,cassandra2:9042")
+	schemaDiffCmd.Flags().StringVarP(&FromKeyspace, "from-keyspace", "i", FromKeyspace, "old_keyspace_name")
+	schemaDiffCmd.Flags().StringVarP(&To.Host, "to-host", "t", To.Host, "cassandra3:9042,cassandra4:9042")
+	schemaDiffCmd.Flags().StringVarP(&ToKeyspace, "to-keyspace", "o", ToKeyspace, "new_keyspace_name")
+	schemaDiffCmd.Flags().StringVarP(&Table, "table", "a", Table, "only compare this table")
+	schemaDiffCmd.Flags().BoolVar(&SchemaJSON, "json", SchemaJSON, "print the differences as JSON")
+	addClusterFlags(schemaDiffCmd.Flags(), "from", &From)
+	addClusterFlags(schemaDiffCmd.Flags(), "to", &To)
+
+	schemaCmd.AddCommand(schemaExportCmd)
+	schemaCmd.AddCommand(schemaApplyCmd)
+	schemaCmd.AddCommand(schemaDiffCmd)
+	rootCmd.AddCommand(schemaCmd)
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleSignals halves the rate limits on SIGUSR1 and doubles them on SIGUSR2, limits which are not
+// set start from the observed rates when halved
+func (tr *Throttler) handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for s := range signals {
+			if s == syscall.SIGUSR1 {
+				log.Println("SIGUSR1 received, halving rate limits")
+				tr.Scale(0.5)
+			} else {
+				log.Println("SIGUSR2 received, doubling rate limits")
+				tr.Scale(2)
+			}
+		}
+	}()
+}
@@ -0,0 +1,5 @@
+package main
+
+// handleSignals does nothing as there are no user signals on windows, the control endpoint can be used
+func (tr *Throttler) handleSignals() {
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gocql/gocql"
+)
+
+// getAlterTableQueries returns the statements bringing an existing target table in line with the
+// source one: missing columns are added and differing options are set. Options unknown to the target
+// cluster, as when it runs another cassandra version, are left as they are.
+func (c *Cassandra) getAlterTableQueries(keyspace string, source *gocql.TableMetadata,
+	differences []*schemaDifference) []string {
+
+	var queries []string
+	var with []string
+	for _, d := range differences {
+		switch d.Kind {
+		case diffMissingColumn:
+			q := fmt.Sprintf("ALTER TABLE %s ADD %s %s", qualifiedName(keyspace, source.Name), quoteIdentifier(d.Name), d.Source)
+			if source.Columns[d.Name].Kind == gocql.ColumnStatic {
+				q += " STATIC"
+			}
+			queries = append(queries, q+";")
+		case diffOption:
+			if d.Source != "" && d.Target != "" {
+				with = append(with, d.Name+" = "+d.Source)
+			}
+		}
+	}
+
+	if len(with) > 0 {
+		queries = append(queries, fmt.Sprintf("ALTER TABLE %s WITH %s;", qualifiedName(keyspace, source.Name),
+			strings.Join(with, " AND ")))
+	}
+
+	return queries
+}
+
+// getSyncTablesQueries returns the statements creating the tables missing on the target keyspace and
+// altering the existing ones. It panics with a report of the tables which can't be altered, as when
+// their primary key or the type of a column differ.
+func (c *Cassandra) getSyncTablesQueries(s1 *gocql.Session, s2 *gocql.Session, k *gocql.KeyspaceMetadata,
+	toKeyspace string, options TransferOptions) []string {
+
+	target, err := s2.KeyspaceMetadata(toKeyspace)
+	if err != nil {
+		panic(err)
+	}
+
+	known := c.getKnownOptions(s2, "tables")
+	var queries []string
+	var refused []*schemaDifference
+	for _, name := range c.getTableNames(k, options.Table) {
+		tableOptions := c.mergeTableOptions(c.getTableOptions(s1, k.Name, name), options.TableOptions)
+		targetTable, ok := target.Tables[name]
+		if !ok {
+			queries = append(queries, c.getCreateTableQuery(toKeyspace, k.Tables[name],
+				c.filterOptions(toKeyspace+"."+name, tableOptions, known)))
+			continue
+		}
+
+		differences := c.getTableDifferences(k.Tables[name], targetTable, tableOptions,
+			c.getTableOptions(s2, toKeyspace, name))
+
+		blocking := false
+		for _, d := range differences {
+			if d.Incompatible && d.Kind != diffMissingColumn {
+				refused = append(refused, d)
+				blocking = true
+			}
+		}
+
+		if !blocking {
+			queries = append(queries, c.getAlterTableQueries(toKeyspace, k.Tables[name], differences)...)
+		}
+	}
+
+	if len(refused) > 0 {
+		for _, d := range refused {
+			log.Println(d.String())
+		}
+		panic(fmt.Errorf("target tables of %s can't be synced, %d incompatible differences", toKeyspace, len(refused)))
+	}
+
+	return queries
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/gocql/gocql"
+)
+
+const (
+	murmur3Partitioner = "org.apache.cassandra.dht.Murmur3Partitioner"
+	randomPartitioner  = "org.apache.cassandra.dht.RandomPartitioner"
+)
+
+// tokenRange is a (start, end] range of the token ring
+type tokenRange struct {
+	start *big.Int
+	end   *big.Int
+}
+
+func (r *tokenRange) String() string {
+	return "(" + r.start.String() + "," + r.end.String() + "]"
+}
+
+// split cuts the range in n contiguous ranges of about the same size
+func (r *tokenRange) split(n int) []*tokenRange {
+	size := new(big.Int).Sub(r.end, r.start)
+	if n <= 1 || size.Cmp(big.NewInt(int64(n))) < 0 {
+		return []*tokenRange{r}
+	}
+
+	step := new(big.Int).Div(size, big.NewInt(int64(n)))
+	var ranges []*tokenRange
+	start := r.start
+	for i := 1; i < n; i++ {
+		end := new(big.Int).Add(r.start, new(big.Int).Mul(step, big.NewInt(int64(i))))
+		ranges = append(ranges, &tokenRange{start: start, end: end})
+		start = end
+	}
+
+	return append(ranges, &tokenRange{start: start, end: r.end})
+}
+
+// bindValues returns the range bounds typed the way the partitioner tokens are
+func (r *tokenRange) bindValues(partitioner string) []interface{} {
+	if partitioner == murmur3Partitioner {
+		return []interface{}{r.start.Int64(), r.end.Int64()}
+	}
+
+	return []interface{}{r.start, r.end}
+}
+
+func (c *Cassandra) getPartitioner(s *gocql.Session) string {
+	var partitioner string
+	if err := s.Query("SELECT partitioner FROM system.local").Scan(&partitioner); err != nil {
+		panic(err)
+	}
+
+	return partitioner
+}
+
+// getTokenBounds returns the exclusive minimum and the maximum token of a partitioner
+func (c *Cassandra) getTokenBounds(partitioner string) (*big.Int, *big.Int, error) {
+	switch partitioner {
+	case murmur3Partitioner:
+		return new(big.Int).Lsh(big.NewInt(-1), 63), new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 63), big.NewInt(1)), nil
+	case randomPartitioner:
+		return big.NewInt(-1), new(big.Int).Lsh(big.NewInt(1), 127), nil
+	default:
+		return nil, nil, fmt.Errorf("token range scans are not supported with %s", partitioner)
+	}
+}
+
+// getRingTokens returns the sorted tokens owned by the nodes of the cluster
+func (c *Cassandra) getRingTokens(s *gocql.Session) ([]*big.Int, error) {
+	var tokens []*big.Int
+	for _, q := range []string{"SELECT tokens FROM system.local", "SELECT tokens FROM system.peers"} {
+		iter := s.Query(q).Iter()
+		var nodeTokens []string
+		for iter.Scan(&nodeTokens) {
+			for _, t := range nodeTokens {
+				token, ok := new(big.Int).SetString(t, 10)
+				if !ok {
+					iter.Close()
+					return nil, fmt.Errorf("invalid token %s", t)
+				}
+				tokens = append(tokens, token)
+			}
+		}
+
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Cmp(tokens[j]) < 0
+	})
+
+	return tokens, nil
+}
+
+// getTokenRanges splits the whole token ring in at least splits ranges. Ranges are aligned on
+// the nodes (and vnodes) ownership when the ring can be read, so each of them is served by its replicas.
+func (c *Cassandra) getTokenRanges(s *gocql.Session, partitioner string, splits int) ([]*tokenRange, error) {
+	min, max, err := c.getTokenBounds(partitioner)
+	if err != nil {
+		return nil, err
+	}
+
+	ring := []*tokenRange{{start: min, end: max}}
+	tokens, err := c.getRingTokens(s)
+	if err != nil {
+		log.Println("Unable to read the token ring, ranges won't be aligned on nodes: " + err.Error())
+	} else if len(tokens) > 0 {
+		ring = nil
+		start := min
+		for _, token := range tokens {
+			if token.Cmp(start) > 0 {
+				ring = append(ring, &tokenRange{start: start, end: token})
+				start = token
+			}
+		}
+
+		if start.Cmp(max) < 0 {
+			ring = append(ring, &tokenRange{start: start, end: max})
+		}
+	}
+
+	perRange := (splits + len(ring) - 1) / len(ring)
+	var ranges []*tokenRange
+	for _, r := range ring {
+		ranges = append(ranges, r.split(perRange)...)
+	}
+
+	return ranges, nil
+}
+
+func (c *Cassandra) getTokenRangeRestriction(table *gocql.TableMetadata) string {
+	var pkColumns []string
+	for _, pk := range table.PartitionKey {
+		pkColumns = append(pkColumns, quoteIdentifier(pk.Name))
+	}
+
+	token := "token(" + strings.Join(pkColumns, ",") + ")"
+
+	return " WHERE " + token + " > ? AND " + token + " <= ?"
+}
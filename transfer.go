@@ -3,29 +3,93 @@ package main
 import (
 	"fmt"
 	"github.com/spf13/cobra"
+	"strings"
+	"time"
 )
 
-var FromHost = ""
-var ToHost = ""
+var From = NewClusterOptions()
+var To = NewClusterOptions()
 var FromKeyspace = ""
 var ToKeyspace = ""
 var Table = ""
 var SkipCreateTables = false
+var SyncSchema = false
+var SkipSchemaCheck = false
+var TruncateCounters = false
 var SkipInsertRowErrors = false
 var SkipRows = 0
+var NullTombstones = false
+var PreserveWriteTime = false
+var Splits = 1
+var TableConcurrency = 4
+var WriteConcurrency = 1
+var WriteLatencyTarget time.Duration
+var GlobalRateLimits RateLimits
+var TableRateLimits RateLimits
+var ControlAddress = ""
+var StateFile = ""
+var Resume = false
+var Replication []string
+var DCMap []string
+var DurableWrites = true
+var TableOptions []string
 
 var transferCmd = &cobra.Command{
 	Use:   "transfer [COMMANDS]",
 	Short: "migrate data from one cassandra instance to another one",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
-		if FromHost == "" {
+		if From.Host == "" {
 			return fmt.Errorf("FROM host is mandatory")
 		}
 
-		if ToHost == "" {
+		if To.Host == "" {
 			return fmt.Errorf("TO host is mandatory")
 		}
 
+		if err := From.resolve("from"); err != nil {
+			return err
+		}
+
+		if err := To.resolve("to"); err != nil {
+			return err
+		}
+
+		if SkipRows > 0 && Splits > 1 {
+			return fmt.Errorf("skip rows can't be used with token range splits")
+		}
+
+		if SyncSchema && SkipCreateTables {
+			return fmt.Errorf("sync schema can't be used when skipping tables creation")
+		}
+
+		if TableConcurrency < 1 || WriteConcurrency < 1 {
+			return fmt.Errorf("table and write concurrency must be at least 1")
+		}
+
+		if WriteLatencyTarget < 0 || (WriteLatencyTarget > 0 && WriteConcurrency < 2) {
+			return fmt.Errorf("write latency target needs a write concurrency of at least 2 to adapt it")
+		}
+
+		for _, limits := range []RateLimits{GlobalRateLimits, TableRateLimits} {
+			if limits.ReadRows < 0 || limits.ReadBytes < 0 || limits.WriteRows < 0 || limits.WriteBytes < 0 {
+				return fmt.Errorf("rate limits can't be negative")
+			}
+		}
+
+		if Resume && StateFile == "" {
+			return fmt.Errorf("resume needs a state file")
+		}
+
+		if Resume && SkipRows > 0 {
+			return fmt.Errorf("skip rows can't be used when resuming")
+		}
+
+		for _, values := range [][]string{Replication, DCMap, TableOptions} {
+			if _, err := parseKeyValues(values); err != nil {
+				return err
+			}
+		}
+
 		if ToKeyspace == "" {
 			ToKeyspace = FromKeyspace
 		}
@@ -33,20 +97,95 @@ var transferCmd = &cobra.Command{
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		replication, _ := parseKeyValues(Replication)
+		dcMap, _ := parseKeyValues(DCMap)
+		tableOptions, _ := parseKeyValues(TableOptions)
+
+		var durableWrites *bool
+		if cmd.Flags().Changed("durable-writes") {
+			durableWrites = &DurableWrites
+		}
+
 		c := Cassandra{}
-		c.TransferCassandraData(FromHost, ToHost, FromKeyspace, ToKeyspace, Table, SkipCreateTables, SkipRows, SkipInsertRowErrors)
+		c.TransferCassandraData(From, To, FromKeyspace, ToKeyspace, TransferOptions{
+			Table:               Table,
+			SkipCreateTables:    SkipCreateTables,
+			SyncSchema:          SyncSchema,
+			SkipSchemaCheck:     SkipSchemaCheck,
+			TruncateCounters:    TruncateCounters,
+			SkipRows:            SkipRows,
+			SkipInsertRowErrors: SkipInsertRowErrors,
+			NullTombstones:      NullTombstones,
+			PreserveWriteTime:   PreserveWriteTime,
+			Splits:              Splits,
+			TableConcurrency:    TableConcurrency,
+			WriteConcurrency:    WriteConcurrency,
+			WriteLatencyTarget:  WriteLatencyTarget,
+			RateLimits:          GlobalRateLimits,
+			TableRateLimits:     TableRateLimits,
+			ControlAddress:      ControlAddress,
+			StateFile:           StateFile,
+			Resume:              Resume,
+			Replication:         replication,
+			DCMap:               dcMap,
+			DurableWrites:       durableWrites,
+			TableOptions:        tableOptions,
+		})
 	},
 }
 
 func init() {
-	transferCmd.Flags().StringVarP(&FromHost, "from-host", "f", FromHost, "cassandra1:9042")
+	transferCmd.Flags().StringVarP(&From.Host, "from-host", "f", From.Host, "cassandra1:9042,cassandra2:9042")
 	transferCmd.Flags().StringVarP(&FromKeyspace, "from-keyspace", "i", FromKeyspace, "old_keyspace_name")
-	transferCmd.Flags().StringVarP(&ToHost, "to-host", "t", ToHost, "cassandra2:9042")
+	transferCmd.Flags().StringVarP(&To.Host, "to-host", "t", To.Host, "cassandra3:9042,cassandra4:9042")
 	transferCmd.Flags().StringVarP(&ToKeyspace, "to-keyspace", "o", ToKeyspace, "new_keyspace_name")
 	transferCmd.Flags().StringVarP(&Table, "table", "a", Table, "table_to_sync")
 	transferCmd.Flags().IntVar(&SkipRows, "skip-rows", SkipRows, "skip rows")
-	transferCmd.Flags().BoolVarP(&SkipCreateTables, "skip-create-tables", "s", SkipCreateTables, "skip create tables")
+	transferCmd.Flags().BoolVarP(&SkipCreateTables, "skip-create-tables", "s", SkipCreateTables, "skip create keyspace and tables, they must already exist on the target")
+	transferCmd.Flags().BoolVar(&SyncSchema, "sync-schema", SyncSchema, "alter existing target tables to add missing columns and update options")
+	transferCmd.Flags().BoolVar(&SkipSchemaCheck, "skip-schema-check", SkipSchemaCheck, "don't check the target tables can receive the source rows before the transfer")
 	transferCmd.Flags().BoolVarP(&SkipInsertRowErrors, "skip-insert-row-errors", "x", SkipCreateTables, "skip insert row errors")
+	transferCmd.Flags().BoolVar(&NullTombstones, "null-tombstones", NullTombstones, "write source nulls as tombstones instead of leaving them out of the written rows")
+	transferCmd.Flags().BoolVar(&PreserveWriteTime, "preserve-writetime", PreserveWriteTime, "keep the write time and TTL of every cell")
+	transferCmd.Flags().BoolVar(&TruncateCounters, "truncate-counters", TruncateCounters, "truncate target counter tables before copying them, as increments add up to the target values")
+	transferCmd.Flags().IntVar(&Splits, "splits", Splits, "read each table with N parallel token range scans")
+	transferCmd.Flags().IntVar(&TableConcurrency, "table-concurrency", TableConcurrency, "number of tables copied at the same time, largest first")
+	transferCmd.Flags().IntVar(&WriteConcurrency, "write-concurrency", WriteConcurrency, "number of rows written at the same time for each table")
+	transferCmd.Flags().DurationVar(&WriteLatencyTarget, "write-latency-target", WriteLatencyTarget, "p99 write latency to stay under by adapting the number of rows written at the same time, from 1 up to --write-concurrency, 0 to write at a fixed concurrency")
+	transferCmd.Flags().Float64Var(&GlobalRateLimits.ReadRows, "max-read-rows", GlobalRateLimits.ReadRows, "maximum rows read per second, 0 for unlimited")
+	transferCmd.Flags().Float64Var(&GlobalRateLimits.ReadBytes, "max-read-bytes", GlobalRateLimits.ReadBytes, "maximum bytes read per second, 0 for unlimited")
+	transferCmd.Flags().Float64Var(&GlobalRateLimits.WriteRows, "max-write-rows", GlobalRateLimits.WriteRows, "maximum rows written per second, 0 for unlimited")
+	transferCmd.Flags().Float64Var(&GlobalRateLimits.WriteBytes, "max-write-bytes", GlobalRateLimits.WriteBytes, "maximum bytes written per second, 0 for unlimited")
+	transferCmd.Flags().Float64Var(&TableRateLimits.ReadRows, "table-max-read-rows", TableRateLimits.ReadRows, "maximum rows read per second from each table, 0 for unlimited")
+	transferCmd.Flags().Float64Var(&TableRateLimits.ReadBytes, "table-max-read-bytes", TableRateLimits.ReadBytes, "maximum bytes read per second from each table, 0 for unlimited")
+	transferCmd.Flags().Float64Var(&TableRateLimits.WriteRows, "table-max-write-rows", TableRateLimits.WriteRows, "maximum rows written per second to each table, 0 for unlimited")
+	transferCmd.Flags().Float64Var(&TableRateLimits.WriteBytes, "table-max-write-bytes", TableRateLimits.WriteBytes, "maximum bytes written per second to each table, 0 for unlimited")
+	transferCmd.Flags().StringVar(&ControlAddress, "control-address", ControlAddress, "serve the rate limits on http://ADDRESS/limits to change them while running, ex: localhost:8089 (SIGUSR1 and SIGUSR2 halve and double them)")
+	transferCmd.Flags().StringVar(&StateFile, "state-file", StateFile, "periodically save the transfer progress to this file")
+	transferCmd.Flags().BoolVar(&Resume, "resume", Resume, "resume the transfer saved in the state file")
+
+	transferCmd.Flags().StringSliceVar(&Replication, "replication", Replication, "override the source keyspace replication, ex: class=NetworkTopologyStrategy,dc1=3")
+	transferCmd.Flags().StringSliceVar(&DCMap, "dc-map", DCMap, "rename source datacenters in the replication, ex: source_dc=target_dc")
+	transferCmd.Flags().BoolVar(&DurableWrites, "durable-writes", DurableWrites, "override the source keyspace durable writes")
+	transferCmd.Flags().StringArrayVar(&TableOptions, "table-option", TableOptions, "override a table option with a CQL value, empty to drop it, ex: gc_grace_seconds=3600")
+
+	addClusterFlags(transferCmd.Flags(), "from", &From)
+	addClusterFlags(transferCmd.Flags(), "to", &To)
 
 	rootCmd.AddCommand(transferCmd)
 }
+
+// parseKeyValues parses a list of key=value flag values
+func parseKeyValues(values []string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, v := range values {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid value %s, key=value expected", v)
+		}
+
+		result[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return result, nil
+}
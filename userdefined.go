@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gocql/gocql"
+)
+
+// user types, functions and aggregates are read from system_schema rather than from the driver
+// metadata, which loses the name of user types nested in other types
+
+var typeIdentifier = regexp.MustCompile(`"(?:[^"]|"")+"|[A-Za-z0-9_]+`)
+
+type userType struct {
+	name       string
+	fieldNames []string
+	fieldTypes []string
+}
+
+// dependencies returns the names of the identifiers used by the type fields, among them the user types it needs
+func (t *userType) dependencies() map[string]bool {
+	names := make(map[string]bool)
+	for _, fieldType := range t.fieldTypes {
+		for _, name := range typeIdentifier.FindAllString(fieldType, -1) {
+			if strings.HasPrefix(name, `"`) {
+				name = strings.Replace(name[1:len(name)-1], `""`, `"`, -1)
+			}
+			names[name] = true
+		}
+	}
+
+	return names
+}
+
+type userFunction struct {
+	name              string
+	argumentNames     []string
+	argumentTypes     []string
+	body              string
+	calledOnNullInput bool
+	language          string
+	returnType        string
+}
+
+type userAggregate struct {
+	name          string
+	argumentTypes []string
+	finalFunc     string
+	initCond      string
+	stateFunc     string
+	stateType     string
+}
+
+// getUserTypes returns the user types of a keyspace, a type always comes after the ones it uses
+func (c *Cassandra) getUserTypes(s *gocql.Session, keyspace string) []*userType {
+	iter := s.Query("SELECT type_name, field_names, field_types FROM system_schema.types WHERE keyspace_name = ?",
+		keyspace).Iter()
+
+	types := make(map[string]*userType)
+	var names []string
+	t := &userType{}
+	for iter.Scan(&t.name, &t.fieldNames, &t.fieldTypes) {
+		types[t.name] = t
+		names = append(names, t.name)
+		t = &userType{}
+	}
+
+	if err := iter.Close(); err != nil {
+		log.Println("Unable to read " + keyspace + " user types: " + err.Error())
+		return nil
+	}
+
+	sort.Strings(names)
+
+	var sorted []*userType
+	created := make(map[string]bool)
+	for len(sorted) < len(names) {
+		progress := false
+		for _, name := range names {
+			if created[name] {
+				continue
+			}
+
+			ready := true
+			for dependency := range types[name].dependencies() {
+				if _, ok := types[dependency]; ok && dependency != name && !created[dependency] {
+					ready = false
+					break
+				}
+			}
+
+			if ready {
+				sorted = append(sorted, types[name])
+				created[name] = true
+				progress = true
+			}
+		}
+
+		if !progress {
+			panic(fmt.Errorf("circular user types dependency in %s", keyspace))
+		}
+	}
+
+	return sorted
+}
+
+func (c *Cassandra) getCreateTypeQuery(keyspace string, t *userType) string {
+	var fields []string
+	for i, name := range t.fieldNames {
+		fields = append(fields, quoteIdentifier(name)+" "+t.fieldTypes[i])
+	}
+
+	return fmt.Sprintf("CREATE TYPE IF NOT EXISTS %s (%s);", qualifiedName(keyspace, t.name), strings.Join(fields, ", "))
+}
+
+func (c *Cassandra) getUserFunctions(s *gocql.Session, keyspace string) []*userFunction {
+	iter := s.Query(`SELECT function_name, argument_names, argument_types, body, called_on_null_input, language,
+		return_type FROM system_schema.functions WHERE keyspace_name = ?`, keyspace).Iter()
+
+	var functions []*userFunction
+	f := &userFunction{}
+	for iter.Scan(&f.name, &f.argumentNames, &f.argumentTypes, &f.body, &f.calledOnNullInput, &f.language, &f.returnType) {
+		functions = append(functions, f)
+		f = &userFunction{}
+	}
+
+	if err := iter.Close(); err != nil {
+		log.Println("Unable to read " + keyspace + " functions: " + err.Error())
+		return nil
+	}
+
+	sort.SliceStable(functions, func(i, j int) bool {
+		if functions[i].name != functions[j].name {
+			return functions[i].name < functions[j].name
+		}
+		return strings.Join(functions[i].argumentTypes, ",") < strings.Join(functions[j].argumentTypes, ",")
+	})
+
+	return functions
+}
+
+func (c *Cassandra) getCreateFunctionQuery(keyspace string, f *userFunction) string {
+	var arguments []string
+	for i, name := range f.argumentNames {
+		arguments = append(arguments, quoteIdentifier(name)+" "+f.argumentTypes[i])
+	}
+
+	onNull := "RETURNS NULL ON NULL INPUT"
+	if f.calledOnNullInput {
+		onNull = "CALLED ON NULL INPUT"
+	}
+
+	body := "$$" + f.body + "$$"
+	if strings.Contains(f.body, "$$") {
+		body = c.getStringOrNumber(f.body)
+	}
+
+	return fmt.Sprintf("CREATE OR REPLACE FUNCTION %s (%s) %s RETURNS %s LANGUAGE %s AS %s;",
+		qualifiedName(keyspace, f.name), strings.Join(arguments, ", "), onNull, f.returnType, f.language, body)
+}
+
+func (c *Cassandra) getUserAggregates(s *gocql.Session, keyspace string) []*userAggregate {
+	iter := s.Query(`SELECT aggregate_name, argument_types, final_func, initcond, state_func, state_type
+		FROM system_schema.aggregates WHERE keyspace_name = ?`, keyspace).Iter()
+
+	var aggregates []*userAggregate
+	a := &userAggregate{}
+	for iter.Scan(&a.name, &a.argumentTypes, &a.finalFunc, &a.initCond, &a.stateFunc, &a.stateType) {
+		aggregates = append(aggregates, a)
+		a = &userAggregate{}
+	}
+
+	if err := iter.Close(); err != nil {
+		log.Println("Unable to read " + keyspace + " aggregates: " + err.Error())
+		return nil
+	}
+
+	sort.SliceStable(aggregates, func(i, j int) bool {
+		if aggregates[i].name != aggregates[j].name {
+			return aggregates[i].name < aggregates[j].name
+		}
+		return strings.Join(aggregates[i].argumentTypes, ",") < strings.Join(aggregates[j].argumentTypes, ",")
+	})
+
+	return aggregates
+}
+
+func (c *Cassandra) getCreateAggregateQuery(keyspace string, a *userAggregate) string {
+	q := fmt.Sprintf("CREATE OR REPLACE AGGREGATE %s (%s) SFUNC %s STYPE %s", qualifiedName(keyspace, a.name),
+		strings.Join(a.argumentTypes, ", "), quoteIdentifier(a.stateFunc), a.stateType)
+
+	if a.finalFunc != "" {
+		q += " FINALFUNC " + quoteIdentifier(a.finalFunc)
+	}
+
+	if a.initCond != "" {
+		q += " INITCOND " + a.initCond
+	}
+
+	return q + ";"
+}
+
+// getUserDefinedQueries returns the DDL of the user types, functions and aggregates of the source
+// keyspace to run in the target one, tables may depend on them
+func (c *Cassandra) getUserDefinedQueries(s *gocql.Session, fromKeyspace string, toKeyspace string) []string {
+	var queries []string
+	for _, t := range c.getUserTypes(s, fromKeyspace) {
+		queries = append(queries, c.getCreateTypeQuery(toKeyspace, t))
+	}
+
+	for _, f := range c.getUserFunctions(s, fromKeyspace) {
+		queries = append(queries, c.getCreateFunctionQuery(toKeyspace, f))
+	}
+
+	for _, a := range c.getUserAggregates(s, fromKeyspace) {
+		queries = append(queries, c.getCreateAggregateQuery(toKeyspace, a))
+	}
+
+	return queries
+}
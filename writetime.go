@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gocql/gocql"
+)
+
+// cellGroup holds the columns of a row sharing the same write time and TTL
+type cellGroup struct {
+	timestamp int64
+	ttl       int
+	columns   []int
+}
+
+// isMultiCellColumn tells if the cells of a column are stored separately, as for non frozen
+// collections and user types. WRITETIME and TTL can't be selected on such columns.
+func (c *Cassandra) isMultiCellColumn(column *gocql.ColumnMetadata) bool {
+	validator := column.Validator
+	if strings.HasPrefix(validator, "frozen<") || strings.Contains(validator, "FrozenType(") {
+		return false
+	}
+
+	// cassandra 2.x marshal class names
+	if strings.HasPrefix(validator, "org.apache.cassandra.db.marshal.") {
+		for _, t := range []string{"ListType(", "SetType(", "MapType(", "UserType("} {
+			if strings.Contains(validator, t) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch column.Type.Type() {
+	case gocql.TypeList, gocql.TypeSet, gocql.TypeMap, gocql.TypeUDT:
+		return true
+	case gocql.TypeCustom:
+		// user types are referenced by their bare name
+		return !strings.ContainsAny(validator, ".'<(")
+	}
+
+	return false
+}
+
+func (c *Cassandra) getWriteTimeColumnsName(table *gocql.TableMetadata) []string {
+	var columns []string
+	for _, columnName := range table.OrderedColumns {
+		column := table.Columns[columnName]
+		if column.Kind != gocql.ColumnRegular && column.Kind != gocql.ColumnStatic {
+			continue
+		}
+
+		if column.Type.Type() == gocql.TypeCounter || c.isMultiCellColumn(column) {
+			continue
+		}
+
+		columns = append(columns, columnName)
+	}
+
+	return columns
+}
+
+// getCellGroups groups the non null regular cells of a row by write time and TTL, oldest first.
+// The WRITETIME and TTL of writeTimeColumns[i] are expected right after the row columns.
+func (c *Cassandra) getCellGroups(columns []string, writeTimeColumns []string, row *rawRow) []*cellGroup {
+	index := make(map[string]int, len(columns))
+	for i, columnName := range columns {
+		index[columnName] = i
+	}
+
+	var groups []*cellGroup
+	for j, columnName := range writeTimeColumns {
+		writeTime := row.values[len(columns)+2*j]
+		ttl := row.values[len(columns)+2*j+1]
+		if writeTime.isNull() {
+			continue
+		}
+
+		g := &cellGroup{timestamp: int64(binary.BigEndian.Uint64(writeTime.data))}
+		if !ttl.isNull() {
+			g.ttl = int(int32(binary.BigEndian.Uint32(ttl.data)))
+		}
+
+		found := false
+		for _, group := range groups {
+			if group.timestamp == g.timestamp && group.ttl == g.ttl {
+				group.columns = append(group.columns, index[columnName])
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			g.columns = []int{index[columnName]}
+			groups = append(groups, g)
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].timestamp != groups[j].timestamp {
+			return groups[i].timestamp < groups[j].timestamp
+		}
+		return groups[i].ttl < groups[j].ttl
+	})
+
+	return groups
+}
+
+func (c *Cassandra) getUpdateQuery(keyspace string, table *gocql.TableMetadata, columns []string,
+	keyColumns []string) string {
+
+	var set []string
+	for _, columnName := range quoteIdentifiers(columns) {
+		set = append(set, columnName+"=?")
+	}
+
+	var where []string
+	for _, columnName := range quoteIdentifiers(keyColumns) {
+		where = append(where, columnName+"=?")
+	}
+
+	return fmt.Sprintf("UPDATE %s USING TIMESTAMP ? AND TTL ? SET %s WHERE %s", qualifiedName(keyspace, table.Name),
+		strings.Join(set, ","), strings.Join(where, " AND "))
+}
+
+// boundStatement is a statement along with the values to bind to it
+type boundStatement struct {
+	query  string
+	values []interface{}
+}
+
+// getWriteTimeStatements returns the statements writing a row with the write time and TTL of each of
+// its cells. The primary key and the multi cell columns, whose TTL is unknown, are inserted at the
+// oldest write time, along with the oldest cells when they don't expire. The other cells are written
+// with one update per distinct write time and TTL, so no cell gets the TTL of another one.
+// The inserted row marker expires with the last cell when all the written cells expire, otherwise it
+// never does.
+func (c *Cassandra) getWriteTimeStatements(t *tableSync, row *rawRow, indexes []int) []boundStatement {
+	keyspace, table, columns := t.toKeyspace, t.table, t.columns
+	values := row.values[:len(columns)]
+	groups := c.getCellGroups(columns, t.writeTimeColumns, row)
+
+	updates := groups
+	if len(groups) > 0 && groups[0].ttl == 0 {
+		updates = groups[1:]
+	}
+
+	updated := make(map[int]bool)
+	expiring := len(groups) > 0
+	ttl := 0
+	for _, g := range groups {
+		if g.ttl == 0 {
+			expiring = false
+		} else if g.ttl > ttl {
+			ttl = g.ttl
+		}
+	}
+	for _, g := range updates {
+		for _, i := range g.columns {
+			updated[i] = true
+		}
+	}
+
+	var keyColumns []string
+	var keyValues []interface{}
+	var insertColumns []string
+	var insertValues []interface{}
+	for _, i := range indexes {
+		columnName := columns[i]
+		kind := table.Columns[columnName].Kind
+		if kind == gocql.ColumnPartitionKey || kind == gocql.ColumnClusteringKey {
+			keyColumns = append(keyColumns, columnName)
+			keyValues = append(keyValues, values[i])
+		} else if !updated[i] && !values[i].isNull() {
+			// a multi cell value, its TTL is unknown
+			expiring = false
+		}
+
+		if !updated[i] {
+			insertColumns = append(insertColumns, columnName)
+			insertValues = append(insertValues, values[i])
+		}
+	}
+
+	if len(groups) == 0 {
+		return []boundStatement{{c.getInsertQuery(keyspace, table, insertColumns), insertValues}}
+	}
+
+	if !expiring {
+		ttl = 0
+	}
+
+	statements := []boundStatement{{
+		query:  c.getInsertQuery(keyspace, table, insertColumns) + " USING TIMESTAMP ? AND TTL ?",
+		values: append(insertValues, groups[0].timestamp, ttl),
+	}}
+
+	for _, g := range updates {
+		var setColumns []string
+		updateValues := []interface{}{g.timestamp, g.ttl}
+		for _, i := range g.columns {
+			setColumns = append(setColumns, columns[i])
+			updateValues = append(updateValues, values[i])
+		}
+		updateValues = append(updateValues, keyValues...)
+
+		statements = append(statements, boundStatement{c.getUpdateQuery(keyspace, table, setColumns, keyColumns), updateValues})
+	}
+
+	return statements
+}
+
+func (c *Cassandra) writeRowWithWriteTime(t *tableSync, row *rawRow, indexes []int) error {
+	for _, statement := range c.getWriteTimeStatements(t, row, indexes) {
+		if err := t.to.Query(statement.query, statement.values...).Exec(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func int64Value(v int64) *rawValue {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(v))
+	return &rawValue{info: nativeType(gocql.TypeBigInt), data: data}
+}
+
+func int32Value(v int32) *rawValue {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, uint32(v))
+	return &rawValue{info: nativeType(gocql.TypeInt), data: data}
+}
+
+// writeTimeCell returns the WRITETIME and TTL selected for a cell, a null cell has neither
+func writeTimeCell(timestamp int64, ttl int32) []*rawValue {
+	if timestamp == 0 {
+		return []*rawValue{{}, {}}
+	}
+	if ttl == 0 {
+		return []*rawValue{int64Value(timestamp), {}}
+	}
+	return []*rawValue{int64Value(timestamp), int32Value(ttl)}
+}
+
+func TestGetWriteTimeStatements(t *testing.T) {
+	c := Cassandra{}
+	table := newTestTable("events",
+		&gocql.ColumnMetadata{Name: "id", Kind: gocql.ColumnPartitionKey, Validator: "int"},
+		&gocql.ColumnMetadata{Name: "a", Kind: gocql.ColumnRegular, Validator: "int"},
+		&gocql.ColumnMetadata{Name: "b", Kind: gocql.ColumnRegular, Validator: "int"},
+		&gocql.ColumnMetadata{Name: "tags", Kind: gocql.ColumnRegular, Validator: "set<text>"})
+	ts := &tableSync{
+		toKeyspace:       "ks",
+		table:            table,
+		columns:          []string{"id", "a", "b", "tags"},
+		writeTimeColumns: []string{"a", "b"},
+	}
+
+	id, a, b := int32Value(1), int32Value(2), int32Value(3)
+	tags := &rawValue{info: nativeType(gocql.TypeSet), data: []byte{0, 0, 0, 0}}
+	updateA := "UPDATE ks.events USING TIMESTAMP ? AND TTL ? SET a=? WHERE id=?"
+	updateB := "UPDATE ks.events USING TIMESTAMP ? AND TTL ? SET b=? WHERE id=?"
+
+	tests := []struct {
+		name     string
+		values   []*rawValue
+		cells    [][]*rawValue
+		expected []boundStatement
+	}{
+		{
+			name:   "only expiring cells",
+			values: []*rawValue{id, a, b, {}},
+			cells:  [][]*rawValue{writeTimeCell(100, 60), writeTimeCell(200, 30)},
+			expected: []boundStatement{
+				// the key expires with the last cell
+				{"INSERT INTO ks.events (id) VALUES (?) USING TIMESTAMP ? AND TTL ?", []interface{}{id, int64(100), 60}},
+				{updateA, []interface{}{int64(100), 60, a, id}},
+				{updateB, []interface{}{int64(200), 30, b, id}},
+			},
+		},
+		{
+			name:   "oldest cell not expiring",
+			values: []*rawValue{id, a, b, {}},
+			cells:  [][]*rawValue{writeTimeCell(100, 0), writeTimeCell(200, 30)},
+			expected: []boundStatement{
+				{"INSERT INTO ks.events (id,a) VALUES (?,?) USING TIMESTAMP ? AND TTL ?", []interface{}{id, a, int64(100), 0}},
+				{updateB, []interface{}{int64(200), 30, b, id}},
+			},
+		},
+		{
+			name:   "newest cell not expiring",
+			values: []*rawValue{id, a, b, {}},
+			cells:  [][]*rawValue{writeTimeCell(100, 60), writeTimeCell(200, 0)},
+			expected: []boundStatement{
+				{"INSERT INTO ks.events (id) VALUES (?) USING TIMESTAMP ? AND TTL ?", []interface{}{id, int64(100), 0}},
+				{updateA, []interface{}{int64(100), 60, a, id}},
+				{updateB, []interface{}{int64(200), 0, b, id}},
+			},
+		},
+		{
+			name:   "expiring cells and a multi cell value",
+			values: []*rawValue{id, a, {}, tags},
+			cells:  [][]*rawValue{writeTimeCell(100, 60), writeTimeCell(0, 0)},
+			expected: []boundStatement{
+				{"INSERT INTO ks.events (id,tags) VALUES (?,?) USING TIMESTAMP ? AND TTL ?", []interface{}{id, tags, int64(100), 0}},
+				{updateA, []interface{}{int64(100), 60, a, id}},
+			},
+		},
+		{
+			name:   "no regular cell",
+			values: []*rawValue{id, {}, {}, tags},
+			cells:  [][]*rawValue{writeTimeCell(0, 0), writeTimeCell(0, 0)},
+			expected: []boundStatement{
+				{"INSERT INTO ks.events (id,tags) VALUES (?,?)", []interface{}{id, tags}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			row := &rawRow{values: append([]*rawValue{}, test.values...)}
+			for _, cell := range test.cells {
+				row.values = append(row.values, cell...)
+			}
+
+			statements := c.getWriteTimeStatements(ts, row, c.getWrittenColumns(ts, row))
+			if !reflect.DeepEqual(statements, test.expected) {
+				t.Errorf("expected\n%v\ngot\n%v", test.expected, statements)
+			}
+		})
+	}
+}